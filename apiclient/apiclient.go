@@ -2,232 +2,425 @@ package apiclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"nsw42/piju-touchscreen-go/artworkcache"
+	"nsw42/piju-touchscreen-go/logging"
+	"nsw42/piju-touchscreen-go/metrics"
 )
 
+var logger = logging.For("apiclient")
+
+// Client talks to a piju server. Host, DecodeMode, StaleTimeout, ArtworkCache,
+// Recorder, Metrics and OnStateChange must be set before calling Run or
+// Replay, and not changed afterwards; all other state is protected by mutex, so the
+// methods of Client may be called from any goroutine.
 type Client struct {
-	IsConnected      bool
-	PlayerStatus     Status
-	Host             string
-	CachedArtworkUri string
-	CachedArtwork    []byte
-}
-
-var httpClient = &http.Client{Timeout: 10 * time.Second}
-
-var artworkBuffers [2]bytes.Buffer
-var currentArtworkBuffer int = 0
-var copyBuffer = make([]byte, 2048)
-
-func extractIntFromJson(jsonObject map[string]any, key string, defaultVal int) int {
-	tempFloat, ok := jsonObject[key].(float64)
-	if !ok {
-		return defaultVal
-	} else {
-		return int(tempFloat)
-	}
-}
-
-func getScanningStatus(reply map[string]any) bool {
-	workerStatusStr, ok := reply["WorkerStatus"].(string)
-	if !ok {
-		return false
-	}
-	return (strings.ToLower(workerStatusStr) != "idle")
-}
-
-func getStatusFromReply(reply map[string]any) Status {
-	statStr := reply["PlayerStatus"]
-	switch statStr {
-	case "stopped":
-		return Stopped
-	case "playing":
-		return Playing
-	case "paused":
-		return Paused
-	default:
-		// Error in reply
-		log.Println("Unrecognised player status: ", statStr)
-		return Stopped
-	}
-}
-
-func getArtistTrackAndStream(reply map[string]any) (bool, string, string, string) {
-	var currentTrack map[string]any
-	isTrack := false
-	streamName, _ := reply["CurrentStream"].(string)
-	currentTrack, ok := reply["CurrentTrack"].(map[string]any)
-	var artistName, trackName string
-	if ok && len(currentTrack) > 0 {
-		isTrack = true
-		artistName, ok = currentTrack["artist"].(string)
-		if !ok {
-			artistName = "Unknown artist"
-		}
-		trackName, ok = currentTrack["title"].(string)
-		if !ok {
-			trackName = "Unknown track"
-		}
+	Host          string
+	DecodeMode    DecodeMode
+	StaleTimeout  time.Duration       // Treat the connection as lost if nothing is heard for this long
+	ArtworkCache  *artworkcache.Cache // May be nil
+	Recorder      *Recorder           // May be nil
+	Metrics       *metrics.Metrics    // May be nil
+	OnStateChange func(state ConnectionState, retryAt time.Time)
+
+	httpClient       *http.Client
+	mutex            sync.Mutex
+	state            ConnectionState
+	retryAt          time.Time // When the next connection attempt is due, if state is Backoff
+	playerStatus     Status
+	playerVolume     int
+	cachedArtworkUri string
+	cachedArtwork    []byte
+	replayArtwork    map[string][]byte // Set while replaying a recorded session
+}
+
+// VolumeStep is the amount by which SendVolumeUp and SendVolumeDown change the volume
+const VolumeStep = 5
+
+// DefaultStaleTimeout is how long the server may be silent, including not
+// replying to pings, before the websocket connection is considered lost
+const DefaultStaleTimeout = 30 * time.Second
+
+const httpTimeout = 10 * time.Second
+
+func NewClient(host string) *Client {
+	return &Client{
+		Host:         host,
+		StaleTimeout: DefaultStaleTimeout,
+		httpClient:   &http.Client{Timeout: httpTimeout},
+		state:        Disconnected,
+		playerStatus: Error,
+		playerVolume: UnknownVolume,
 	}
-	return isTrack, artistName, trackName, streamName
 }
 
-func (client *Client) getArtworkFromReply(reply map[string]any) (string, []byte) {
-	artworkUri, ok := reply["CurrentArtwork"].(string)
-	if !ok {
-		return "", nil
+// State returns the current connection state, and when the next connection
+// attempt is due if the state is Backoff
+func (client *Client) State() (ConnectionState, time.Time) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.state, client.retryAt
+}
+
+func (client *Client) PlayerStatus() Status {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.playerStatus
+}
+
+func (client *Client) PlayerVolume() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.playerVolume
+}
+
+func (client *Client) setPlayerState(status Status, volume int) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.playerStatus = status
+	client.playerVolume = volume
+}
+
+// getArtwork returns the artwork at the given URI, fetching it only if it
+// differs from last time. The returned slice is shared between every
+// NowPlaying for that URI, so must not be modified.
+func (client *Client) getArtwork(artworkUri string) []byte {
+	if artworkUri == "" {
+		return nil
 	}
 
-	if artworkUri != client.CachedArtworkUri {
-		// Need to update our cache
-		client.CachedArtwork = client.fetchArtwork(artworkUri)
-		if client.CachedArtwork != nil {
-			// If the GET failed, we should be prepared to try again, so only update the
-			// cached URI if the GET succeded
-			client.CachedArtworkUri = artworkUri
-		}
+	client.mutex.Lock()
+	cachedUri, cachedArtwork := client.cachedArtworkUri, client.cachedArtwork
+	replayArtwork := client.replayArtwork
+	client.mutex.Unlock()
+	if artworkUri == cachedUri {
+		return cachedArtwork
+	}
+	if replayArtwork != nil {
+		return replayArtwork[artworkUri]
 	}
-	return artworkUri, client.CachedArtwork
-}
 
-func (client *Client) ConnectWS(showNowPlaying func(NowPlaying)) {
-	// Returns whether or not connection was successful
+	// Need to update our cache. Don't hold the lock while we do so.
+	artwork := client.fetchArtwork(artworkUri)
+	if artwork == nil {
+		// If the GET failed, we should be prepared to try again, so leave the cache alone
+		return nil
+	}
+	client.mutex.Lock()
+	client.cachedArtworkUri = artworkUri
+	client.cachedArtwork = artwork
+	client.mutex.Unlock()
+	if client.Recorder != nil {
+		client.Recorder.recordArtwork(artworkUri, artwork)
+	}
+	return artwork
+}
 
-	if client.IsConnected {
-		log.Println("Warning - attempted to reconnect when already connected")
-		return
+func (client *Client) dialWS(ctx context.Context) (*websocket.Conn, error) {
+	ws, err := url.Parse(client.Host)
+	if err != nil {
+		return nil, err
 	}
-	client.IsConnected = false
-	ws, _ := url.Parse(client.Host)
 	ws.Scheme = "ws"
 	ws.Path = "ws"
-	conn, _, err := websocket.DefaultDialer.Dial(ws.String(), nil)
-	if err != nil {
-		log.Println("Failed to connect - retry in 5s")
-		return
-	}
-	client.IsConnected = true
-
-	go client.handleWsMessages(conn, showNowPlaying)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, ws.String(), nil)
+	return conn, err
 }
 
 func (client *Client) handleWsMessages(conn *websocket.Conn, showNowPlaying func(NowPlaying)) {
+	// The server only sends messages when something changes, so ping it
+	// regularly to distinguish a quiet server from one that has vanished
+	// without closing the connection. Every message or pong postpones the
+	// read deadline; if it passes, ReadMessage fails and we reconnect.
+	staleTimeout := client.StaleTimeout
+	if staleTimeout <= 0 {
+		staleTimeout = DefaultStaleTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(staleTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(staleTimeout))
+	})
+	stopPinging := make(chan struct{})
+	defer close(stopPinging)
+	go pingWS(conn, staleTimeout/3, stopPinging)
+
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
+			logger.Warn("Connection lost", "host", client.Host, "err", err)
 			conn.Close()
-			client.IsConnected = false
-			client.PlayerStatus = Error
+			client.setPlayerState(Error, UnknownVolume)
 			showNowPlaying(NowPlaying{Status: Error})
 			return
 		}
+		conn.SetReadDeadline(time.Now().Add(staleTimeout))
+		client.Metrics.StatusReceived()
+		if client.Recorder != nil {
+			client.Recorder.recordMessage(message)
+		}
 
-		status := client.statusFromReader(bytes.NewReader(message))
-		log.Println("Status update received: ", status.ArtistName, status.TrackName, status.Status, status.StreamName)
-		client.PlayerStatus = status.Status
+		status, err := client.statusFromReader(bytes.NewReader(message))
+		if err != nil {
+			logger.Warn("Ignoring status update", "err", err)
+			continue
+		}
+		logger.Debug("Status update received", "artist", status.ArtistName, "track", status.TrackName, "status", status.Status, "stream", status.StreamName)
+		client.setPlayerState(status.Status, status.Volume)
 		showNowPlaying(status)
 	}
 }
 
+func pingWS(conn *websocket.Conn, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// A failed ping needs no handling here: the read deadline will expire
+			deadline := time.Now().Add(interval)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				logger.Warn("Failed to ping server", "err", err)
+			}
+		}
+	}
+}
+
 func (client *Client) GetCurrentStatus() NowPlaying {
-	resp, err := httpClient.Get(client.Host)
+	resp, err := client.httpClient.Get(client.Host)
 	if err != nil {
-		log.Println("Error getting server status: ", err)
+		logger.Error("Error getting server status", "err", err)
 		return NowPlaying{Status: Error}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Println("Error getting server status: ", resp.StatusCode)
+		logger.Error("Error getting server status", "status", resp.StatusCode)
 		return NowPlaying{Status: Error}
 	}
 
-	return client.statusFromReader(resp.Body)
+	stat, err := client.statusFromReader(resp.Body)
+	if err != nil {
+		logger.Error("Error getting server status", "err", err)
+		return NowPlaying{Status: Error}
+	}
+	return stat
 }
 
-func (client *Client) statusFromReader(reader io.Reader) NowPlaying {
-	stat := NowPlaying{}
-	var reply map[string]any
-	if err := json.NewDecoder(reader).Decode(&reply); err != nil {
-		log.Println("Error decoding JSON: ", err)
-		return stat
+func (client *Client) statusFromReader(reader io.Reader) (NowPlaying, error) {
+	msg, err := decodeStatusMessage(reader, client.DecodeMode)
+	if err != nil {
+		return NowPlaying{Status: Error}, err
 	}
 
-	stat.Status = getStatusFromReply(reply)
-	stat.IsTrack, stat.ArtistName, stat.TrackName, stat.StreamName = getArtistTrackAndStream(reply)
-	stat.TrackNumber = extractIntFromJson(reply, "CurrentTrackIndex", 0)
-	stat.AlbumTracks = extractIntFromJson(reply, "MaximumTrackIndex", 0)
-	stat.ArtworkUri, stat.Artwork = client.getArtworkFromReply(reply)
-	stat.Scanning = getScanningStatus(reply)
+	stat := msg.toNowPlaying(time.Now())
+	stat.Artwork = client.getArtwork(stat.ArtworkUri)
+	return stat, nil
+}
 
-	return stat
+// FetchArtwork returns the artwork at the given URI, which may be relative
+// to the server, or nil if it cannot be fetched. It is intended for artwork
+// other than that of the current track, such as thumbnails, and uses the
+// artwork cache if there is one.
+func (client *Client) FetchArtwork(uri string) []byte {
+	if uri == "" {
+		return nil
+	}
+	return client.fetchArtwork(uri)
 }
 
 func (client *Client) fetchArtwork(uri string) []byte {
 	if strings.HasPrefix(uri, "/") {
 		uri = client.Host + uri
 	}
-	resp, err := httpClient.Get(uri)
+
+	var cached []byte
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
 	if err != nil {
 		return nil
 	}
+	if client.ArtworkCache != nil {
+		var validators artworkcache.Validators
+		var ok bool
+		if cached, validators, ok = client.ArtworkCache.Get(uri); ok {
+			if validators.ETag == "" && validators.LastModified == "" {
+				// No way to ask whether it's changed: assume it hasn't
+				return cached
+			}
+			if validators.ETag != "" {
+				req.Header.Set("If-None-Match", validators.ETag)
+			}
+			if validators.LastModified != "" {
+				req.Header.Set("If-Modified-Since", validators.LastModified)
+			}
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		client.Metrics.ArtworkFetched(metrics.ArtworkError, 0, time.Since(start))
+		// Better to show possibly out-of-date artwork than none at all
+		return cached
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		client.Metrics.ArtworkFetched(metrics.ArtworkNotModified, 0, time.Since(start))
+		return cached
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil
+		client.Metrics.ArtworkFetched(metrics.ArtworkError, 0, time.Since(start))
+		return cached
 	}
-	currentBuffer := &artworkBuffers[currentArtworkBuffer]
-	currentArtworkBuffer = 1 - currentArtworkBuffer
-	currentBuffer.Reset()
-	_, err = io.CopyBuffer(currentBuffer, resp.Body, copyBuffer)
+	// Always read into a new buffer: the UI may still be using the previous one
+	artwork, err := io.ReadAll(resp.Body)
 	if err != nil {
+		client.Metrics.ArtworkFetched(metrics.ArtworkError, len(artwork), time.Since(start))
 		return nil
 	}
-	return currentBuffer.Bytes()
+	client.Metrics.ArtworkFetched(metrics.ArtworkOK, len(artwork), time.Since(start))
+	if client.ArtworkCache != nil {
+		validators := artworkcache.Validators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := client.ArtworkCache.Put(uri, artwork, validators); err != nil {
+			logger.Error("Error caching artwork", "uri", uri, "err", err)
+		}
+	}
+	return artwork
 }
 
-func (client *Client) SendPause() {
-	client.SendSimpleCommand("player/pause", "pause")
+func (client *Client) SendPause() error {
+	return client.SendSimpleCommand("player/pause", "pause")
 }
 
-func (client *Client) SendResume() {
-	client.SendSimpleCommand("player/resume", "resume")
+func (client *Client) SendResume() error {
+	return client.SendSimpleCommand("player/resume", "resume")
 }
 
-func (client *Client) SendResumeType(playerType string) {
+func (client *Client) SendResumeType(playerType string) error {
 	data := map[string]string{
 		"player": playerType,
 	}
-	buf, _ := json.Marshal(data)
-	body := bytes.NewReader(buf)
-	resp, err := httpClient.Post(client.Host+"player/resume", "application/json", body)
-	if err != nil {
-		log.Println("Failed to send command to server: ", err)
-		return
+	return client.SendJsonCommand("player/resume", data, "resume "+playerType)
+}
+
+func (client *Client) SendNext() error {
+	return client.SendSimpleCommand("player/next", "skip to next track")
+}
+
+func (client *Client) SendPrevious() error {
+	return client.SendSimpleCommand("player/previous", "skip to previous track")
+}
+
+func (client *Client) SetVolume(volume int) error {
+	volume = max(0, min(100, volume))
+	data := map[string]int{
+		"volume": volume,
 	}
-	defer resp.Body.Close()
+	return client.SendJsonCommand("player/volume", data, "set volume")
+}
+
+func (client *Client) SendVolumeUp() error {
+	return client.stepVolume(VolumeStep)
+}
+
+func (client *Client) SendVolumeDown() error {
+	return client.stepVolume(-VolumeStep)
+}
+
+func (client *Client) stepVolume(delta int) error {
+	volume := client.PlayerVolume()
+	if volume == UnknownVolume {
+		return &CommandError{Kind: StateError, Operation: "change volume", Message: "current volume unknown"}
+	}
+	return client.SetVolume(volume + delta)
+}
+
+func (client *Client) SendSeek(position time.Duration) error {
+	data := map[string]float64{
+		"position": max(position, 0).Seconds(),
+	}
+	return client.SendJsonCommand("player/seek", data, "seek")
+}
+
+func (client *Client) SetShuffle(on bool) error {
+	data := map[string]bool{
+		"shuffle": on,
+	}
+	return client.SendJsonCommand("player/shuffle", data, "set shuffle")
+}
+
+func (client *Client) SetRepeat(on bool) error {
+	data := map[string]bool{
+		"repeat": on,
+	}
+	return client.SendJsonCommand("player/repeat", data, "set repeat")
+}
+
+// SendJsonCommand posts data, encoded as JSON, to the given endpoint. Any
+// failure is logged, and returned as a *CommandError.
+func (client *Client) SendJsonCommand(uriSuffix string, data any, operationDesc string) error {
+	buf, _ := json.Marshal(data)
+	return client.postCommand(uriSuffix, bytes.NewReader(buf), operationDesc)
+}
+
+// SendSimpleCommand posts an empty request to the given endpoint. Any
+// failure is logged, and returned as a *CommandError.
+func (client *Client) SendSimpleCommand(uriSuffix string, operationDesc string) error {
+	return client.postCommand(uriSuffix, nil, operationDesc)
 }
 
-func (client *Client) SendNext() {
-	client.SendSimpleCommand("player/next", "skip to next track")
+func (client *Client) postCommand(uriSuffix string, body io.Reader, operationDesc string) error {
+	start := time.Now()
+	err := client.sendCommand(uriSuffix, body, operationDesc)
+	// Label the metrics by endpoint: descriptions can include e.g. album titles
+	client.Metrics.CommandSent(uriSuffix, time.Since(start), err)
+	return err
 }
 
-func (client *Client) SendPrevious() {
-	client.SendSimpleCommand("player/previous", "skip to previous track")
+func (client *Client) sendCommand(uriSuffix string, body io.Reader, operationDesc string) error {
+	resp, err := client.httpClient.Post(client.Host+uriSuffix, "application/json", body)
+	if err != nil {
+		logger.Error("Failed to send command to server", "operation", operationDesc, "err", err)
+		return &CommandError{Kind: NetworkError, Operation: operationDesc, Err: err}
+	}
+	defer resp.Body.Close()
+	if err := commandErrorFromResponse(resp, operationDesc); err != nil {
+		logger.Error("Command failed", "operation", operationDesc, "err", err)
+		return err
+	}
+	return nil
 }
 
-func (client *Client) SendSimpleCommand(uriSuffix string, operationDesc string) {
-	resp, err := httpClient.Post(client.Host+uriSuffix, "application/json", nil)
+// getJson fetches the given endpoint and decodes the JSON reply into result.
+// Any failure is logged, and returned as a *CommandError.
+func (client *Client) getJson(uriSuffix string, result any, operationDesc string) error {
+	resp, err := client.httpClient.Get(client.Host + uriSuffix)
 	if err != nil {
-		log.Println("Failed to send "+operationDesc+" command to server: ", err)
-		return
+		logger.Error("Failed to get data from server", "operation", operationDesc, "err", err)
+		return &CommandError{Kind: NetworkError, Operation: operationDesc, Err: err}
 	}
 	defer resp.Body.Close()
+	if err := commandErrorFromResponse(resp, operationDesc); err != nil {
+		logger.Error("Request failed", "operation", operationDesc, "err", err)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		logger.Error("Invalid reply from server", "operation", operationDesc, "err", err)
+		return &CommandError{Kind: ServerError, Operation: operationDesc, StatusCode: resp.StatusCode, Message: "invalid reply from server"}
+	}
+	return nil
 }
@@ -0,0 +1,341 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"nsw42/piju-touchscreen-go/artworkcache"
+	"nsw42/piju-touchscreen-go/fakeserver"
+)
+
+const (
+	testMessages    = 200
+	testArtworkSize = 4096
+)
+
+// testArtwork is the artwork served for each URI: every byte is the same,
+// so a buffer that has been overwritten by other artwork is easy to spot
+var testArtwork = map[string]byte{
+	"artwork/a": 'a',
+	"artwork/b": 'b',
+}
+
+// newTestServer serves artwork, and sends testMessages status messages to
+// each websocket client, alternating between the two pieces of artwork
+func newTestServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client joins its host, which ends in /, to URIs starting with /
+		path := strings.TrimLeft(r.URL.Path, "/")
+		if fill, ok := testArtwork[path]; ok {
+			w.Write(bytes.Repeat([]byte{fill}, testArtworkSize))
+			return
+		}
+		if path != "ws" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := range testMessages {
+			artwork := "/artwork/a"
+			if i%2 == 1 {
+				artwork = "/artwork/b"
+			}
+			message := fmt.Sprintf(`{"PlayerStatus": "playing", "CurrentTrack": {"artist": "Artist", "title": "Track %d"}, "CurrentArtwork": %q, "PlayerVolume": %d}`, i, artwork, i%100)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return
+			}
+		}
+		// Keep the connection open until the client closes it
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// checkArtwork reports an error if artwork isn't entirely the artwork for uri
+func checkArtwork(t *testing.T, uri string, artwork []byte) {
+	t.Helper()
+	fill := testArtwork[strings.TrimLeft(uri, "/")]
+	if len(artwork) != testArtworkSize || bytes.Count(artwork, []byte{fill}) != testArtworkSize {
+		t.Errorf("Artwork for %s has been corrupted", uri)
+	}
+}
+
+// TestConcurrentAccess reads the client's state from several goroutines
+// while status messages arrive, and checks that the artwork handed to the
+// UI is never overwritten. It is only meaningful with -race.
+func TestConcurrentAccess(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(server.URL + "/")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	var received []NowPlaying
+	allReceived := make(chan struct{})
+	go client.Run(ctx, func(nowPlaying NowPlaying) {
+		if nowPlaying.Status == Error {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, nowPlaying)
+		if len(received) == testMessages {
+			close(allReceived)
+		}
+	})
+
+	var readers sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client.State()
+				client.PlayerStatus()
+				client.PlayerVolume()
+				checkArtwork(t, "/artwork/a", client.fetchArtwork("/artwork/a"))
+				// Read the artwork the UI has been given while more arrives
+				mutex.Lock()
+				if len(received) > 0 {
+					latest := received[len(received)-1]
+					checkArtwork(t, latest.ArtworkUri, latest.Artwork)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	select {
+	case <-allReceived:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for status messages")
+	}
+	close(stop)
+	readers.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, nowPlaying := range received {
+		if want := fmt.Sprintf("Track %d", i); nowPlaying.TrackName != want {
+			t.Errorf("Message %d: got track %q, want %q", i, nowPlaying.TrackName, want)
+		}
+		checkArtwork(t, nowPlaying.ArtworkUri, nowPlaying.Artwork)
+	}
+	if state, _ := client.State(); state != Connected {
+		t.Errorf("Got state %v, want connected", state)
+	}
+	if status := client.PlayerStatus(); status != Playing {
+		t.Errorf("Got player status %v, want playing", status)
+	}
+	if volume := client.PlayerVolume(); volume != (testMessages-1)%100 {
+		t.Errorf("Got volume %d, want %d", volume, (testMessages-1)%100)
+	}
+}
+
+// TestArtworkHandoff checks that fetching new artwork doesn't disturb the
+// buffer holding the previous artwork, which the UI may still be showing
+func TestArtworkHandoff(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(server.URL + "/")
+
+	first := client.getArtwork("/artwork/a")
+	if again := client.getArtwork("/artwork/a"); &again[0] != &first[0] {
+		t.Error("Unchanged artwork was fetched again")
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uri := "/artwork/a"
+			if i%2 == 1 {
+				uri = "/artwork/b"
+			}
+			checkArtwork(t, uri, client.getArtwork(uri))
+		}()
+	}
+	wg.Wait()
+	checkArtwork(t, "/artwork/a", first)
+}
+
+// TestArtworkValidators checks that cached artwork is revalidated with the
+// server, and used when the server says it's unchanged or can't be reached
+func TestArtworkValidators(t *testing.T) {
+	server := newFakeServer(t)
+	dir := t.TempDir()
+	newClient := func() *Client {
+		cache, err := artworkcache.New(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewClient(server.URL)
+		client.ArtworkCache = cache
+		return client
+	}
+	client := newClient()
+	first := bytes.Repeat([]byte{'1'}, testArtworkSize)
+	second := bytes.Repeat([]byte{'2'}, testArtworkSize)
+	check := func(what string, got []byte, want []byte) {
+		t.Helper()
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got the wrong artwork", what)
+		}
+	}
+
+	server.SetArtwork("/artwork/1", first, `"v1"`)
+	check("First fetch", client.fetchArtwork("/artwork/1"), first)
+
+	// The server only sends new data if the ETag changes
+	server.SetArtwork("/artwork/1", second, `"v1"`)
+	check("Not modified", client.fetchArtwork("/artwork/1"), first)
+	server.SetArtwork("/artwork/1", second, `"v2"`)
+	check("Modified", client.fetchArtwork("/artwork/1"), second)
+
+	// Out-of-date artwork is better than none
+	server.InjectFault("/artwork/1", fakeserver.Fault{CloseConnection: true})
+	check("Server unreachable", client.fetchArtwork("/artwork/1"), second)
+	server.InjectFault("/artwork/1", fakeserver.Fault{StatusCode: http.StatusInternalServerError})
+	check("Server error", client.fetchArtwork("/artwork/1"), second)
+	server.ClearFaults()
+
+	// The cache, and its validators, survive a restart
+	server.SetArtwork("/artwork/1", first, `"v2"`)
+	check("After restart", newClient().fetchArtwork("/artwork/1"), second)
+
+	// Without validators, cached artwork is assumed to be up to date
+	server.SetArtwork("/artwork/2", first, "")
+	check("No validators", client.fetchArtwork("/artwork/2"), first)
+	server.SetArtwork("/artwork/2", second, "")
+	check("Unvalidated", client.fetchArtwork("/artwork/2"), first)
+}
+
+// TestBrowse checks that each browse call fetches the right endpoint, and
+// understands the server's reply
+func TestBrowse(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL)
+
+	track := map[string]any{"link": "/tracks/7", "title": "Track", "artist": "Artist", "album": "Album", "tracknumber": 2, "duration": 180.5}
+	wantTrack := Track{Link: "/tracks/7", Title: "Track", Artist: "Artist", Album: "Album", TrackNumber: 2, Duration: 180.5}
+	artist := map[string]any{"name": "Artist", "link": "/artists/Artist"}
+	wantArtist := Artist{Name: "Artist", Link: "/artists/Artist"}
+	album := map[string]any{"link": "/albums/3", "title": "Album", "artist": "Artist", "artwork": "/artwork/3"}
+	wantAlbum := Album{Link: "/albums/3", Title: "Album", Artist: "Artist", Artwork: "/artwork/3"}
+	wantAlbumTracks := wantAlbum
+	wantAlbumTracks.Tracks = []Track{wantTrack}
+
+	tests := []struct {
+		name  string
+		path  string
+		reply any
+		get   func() (any, error)
+		want  any
+	}{
+		{
+			name:  "queue",
+			path:  "/queue/",
+			reply: []any{map[string]any{"queuepos": 1, "link": "/tracks/7", "title": "Track", "artist": "Artist", "album": "Album", "artwork": "/artwork/3"}},
+			get:   func() (any, error) { return client.GetQueue() },
+			want:  []QueueEntry{{QueuePos: 1, Link: "/tracks/7", Title: "Track", Artist: "Artist", Album: "Album", Artwork: "/artwork/3"}},
+		},
+		{
+			name:  "artists",
+			path:  "/artists/",
+			reply: []any{artist},
+			get:   func() (any, error) { return client.GetArtists() },
+			want:  []Artist{wantArtist},
+		},
+		{
+			name:  "artist albums",
+			path:  "/artists/Artist",
+			reply: []any{album},
+			get:   func() (any, error) { return client.GetArtistAlbums(wantArtist) },
+			want:  []Album{wantAlbum},
+		},
+		{
+			name:  "album",
+			path:  "/albums/3",
+			reply: map[string]any{"link": "/albums/3", "title": "Album", "artist": "Artist", "artwork": "/artwork/3", "tracks": []any{track}},
+			get:   func() (any, error) { return client.GetAlbum("/albums/3") },
+			want:  wantAlbumTracks,
+		},
+		{
+			name:  "playlists",
+			path:  "/playlists/",
+			reply: []any{map[string]any{"link": "/playlists/1", "title": "Playlist"}},
+			get:   func() (any, error) { return client.GetPlaylists() },
+			want:  []Playlist{{Link: "/playlists/1", Title: "Playlist"}},
+		},
+		{
+			name:  "playlist",
+			path:  "/playlists/1",
+			reply: map[string]any{"link": "/playlists/1", "title": "Playlist", "tracks": []any{track, track}},
+			get:   func() (any, error) { return client.GetPlaylist("/playlists/1") },
+			want:  Playlist{Link: "/playlists/1", Title: "Playlist", Tracks: []Track{wantTrack, wantTrack}},
+		},
+		{
+			name:  "search",
+			path:  "/search/two words",
+			reply: map[string]any{"artists": []any{artist}, "albums": []any{album}, "tracks": []any{track}},
+			get:   func() (any, error) { return client.Search("two words") },
+			want:  SearchResults{Artists: []Artist{wantArtist}, Albums: []Album{wantAlbum}, Tracks: []Track{wantTrack}},
+		},
+		{
+			name:  "radio stations",
+			path:  "/radio/",
+			reply: []any{map[string]any{"name": "Station", "link": "/radio/1", "artwork": "/artwork/radio1"}},
+			get:   func() (any, error) { return client.GetRadioStations() },
+			want:  []RadioStation{{Name: "Station", Link: "/radio/1", Artwork: "/artwork/radio1"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var commandErr *CommandError
+			if _, err := test.get(); !errors.As(err, &commandErr) || commandErr.StatusCode != http.StatusNotFound {
+				t.Errorf("Before the reply is set: got error %v, want not found", err)
+			}
+
+			server.SetResponse(test.path, test.reply)
+			got, err := test.get()
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %+v, want %+v", got, test.want)
+			}
+
+			server.SetResponse(test.path, "not the expected reply")
+			if _, err := test.get(); !errors.As(err, &commandErr) || commandErr.Kind != ServerError {
+				t.Errorf("Got error %v for an invalid reply, want a server error", err)
+			}
+		})
+	}
+}
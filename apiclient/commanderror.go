@@ -0,0 +1,100 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CommandErrorKind int64
+
+const (
+	NetworkError CommandErrorKind = iota // The server could not be reached
+	HTTPError                            // The server replied with an unsuccessful status code
+	ServerError                          // The server replied with an explanation of what went wrong
+	StateError                           // The command makes no sense in the current state, so wasn't sent
+)
+
+func (k CommandErrorKind) String() string {
+	switch k {
+	case NetworkError:
+		return "network error"
+	case HTTPError:
+		return "HTTP error"
+	case ServerError:
+		return "server error"
+	case StateError:
+		return "state error"
+	}
+	return "???"
+}
+
+// CommandError describes why a command sent to the server failed
+type CommandError struct {
+	Kind       CommandErrorKind
+	Operation  string // What we were trying to do, e.g. "pause"
+	StatusCode int    // Only set for HTTPError and ServerError
+	Message    string // Only set for ServerError and StateError
+	Err        error  // Only set for NetworkError
+}
+
+func (e *CommandError) Error() string {
+	prefix := "Failed to " + e.Operation + ": "
+	switch e.Kind {
+	case NetworkError:
+		return prefix + "could not reach server"
+	case HTTPError:
+		return prefix + "server returned " + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+	}
+	return prefix + e.Message
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// maxErrorMessageLen limits how much of an error reply we try to show
+const maxErrorMessageLen = 200
+
+// commandErrorFromResponse checks the reply to a command, returning nil if
+// it was successful
+func commandErrorFromResponse(resp *http.Response, operationDesc string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if message := errorMessageFromBody(body); message != "" {
+		return &CommandError{Kind: ServerError, Operation: operationDesc, StatusCode: resp.StatusCode, Message: message}
+	}
+	return &CommandError{Kind: HTTPError, Operation: operationDesc, StatusCode: resp.StatusCode}
+}
+
+// errorMessageFromBody extracts a human-readable explanation from an error
+// reply, which may be a JSON object or plain text
+func errorMessageFromBody(body []byte) string {
+	var reply map[string]any
+	if json.Unmarshal(body, &reply) == nil {
+		for _, key := range []string{"error", "message", "description"} {
+			if message, ok := reply[key].(string); ok && message != "" {
+				return truncate(message, maxErrorMessageLen)
+			}
+		}
+		return ""
+	}
+	message := strings.TrimSpace(string(body))
+	if strings.HasPrefix(message, "<") {
+		// Probably an HTML error page: not worth showing
+		return ""
+	}
+	return truncate(message, maxErrorMessageLen)
+}
+
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen]) + "…"
+}
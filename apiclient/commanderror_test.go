@@ -0,0 +1,159 @@
+package apiclient
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"nsw42/piju-touchscreen-go/fakeserver"
+)
+
+func TestCommandErrors(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL)
+	client.httpClient.Timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		fault      *fakeserver.Fault
+		kind       CommandErrorKind
+		statusCode int
+		message    string
+	}{
+		{name: "success"},
+		{
+			name:       "status code only",
+			fault:      &fakeserver.Fault{StatusCode: http.StatusInternalServerError},
+			kind:       HTTPError,
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "HTML error page",
+			fault:      &fakeserver.Fault{StatusCode: http.StatusBadGateway, Body: "<html><body>Bad gateway</body></html>"},
+			kind:       HTTPError,
+			statusCode: http.StatusBadGateway,
+		},
+		{
+			name:       "JSON explanation",
+			fault:      &fakeserver.Fault{StatusCode: http.StatusConflict, Body: `{"error": "Nothing to resume"}`},
+			kind:       ServerError,
+			statusCode: http.StatusConflict,
+			message:    "Nothing to resume",
+		},
+		{
+			name:       "plain text explanation",
+			fault:      &fakeserver.Fault{StatusCode: http.StatusBadRequest, Body: "Volume out of range\n"},
+			kind:       ServerError,
+			statusCode: http.StatusBadRequest,
+			message:    "Volume out of range",
+		},
+		{
+			name:  "connection dropped",
+			fault: &fakeserver.Fault{CloseConnection: true},
+			kind:  NetworkError,
+		},
+		{
+			name:  "timeout",
+			fault: &fakeserver.Fault{Delay: 500 * time.Millisecond},
+			kind:  NetworkError,
+		},
+	}
+	commands := []struct {
+		path string
+		send func() error
+	}{
+		{"/player/pause", client.SendPause},
+		{"/player/volume", func() error { return client.SetVolume(50) }},
+	}
+	for _, test := range tests {
+		for _, command := range commands {
+			t.Run(test.name+" "+command.path, func(t *testing.T) {
+				server.ClearFaults()
+				if test.fault != nil {
+					server.InjectFault(command.path, *test.fault)
+				}
+				err := command.send()
+				if test.fault == nil {
+					if err != nil {
+						t.Fatalf("Got error %v", err)
+					}
+					return
+				}
+				var commandErr *CommandError
+				if !errors.As(err, &commandErr) {
+					t.Fatalf("Got error %v, want a *CommandError", err)
+				}
+				if commandErr.Kind != test.kind {
+					t.Errorf("Got kind %v, want %v", commandErr.Kind, test.kind)
+				}
+				if commandErr.StatusCode != test.statusCode {
+					t.Errorf("Got status code %d, want %d", commandErr.StatusCode, test.statusCode)
+				}
+				if commandErr.Message != test.message {
+					t.Errorf("Got message %q, want %q", commandErr.Message, test.message)
+				}
+				if (commandErr.Kind == NetworkError) != (errors.Unwrap(err) != nil) {
+					t.Errorf("Got underlying error %v for %v", errors.Unwrap(err), commandErr.Kind)
+				}
+			})
+		}
+	}
+}
+
+func TestCommandBodies(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL)
+
+	if err := client.SetVolume(150); err != nil {
+		t.Fatal(err)
+	}
+	command, ok := server.WaitForCommand("/player/volume", time.Second)
+	if !ok {
+		t.Fatal("Volume command not received")
+	}
+	var volume map[string]int
+	if err := command.Decode(&volume); err != nil || volume["volume"] != 100 {
+		t.Errorf("Got volume command %s, want volume clamped to 100", command.Body)
+	}
+
+	playlist := Playlist{Link: "/playlists/1"}
+	if err := client.SendPlayPlaylistFromTrack(playlist, 3); err != nil {
+		t.Fatal(err)
+	}
+	command, ok = server.WaitForCommand("/player/play", time.Second)
+	if !ok {
+		t.Fatal("Play command not received")
+	}
+	var play struct {
+		Playlist string `json:"playlist"`
+		Index    int    `json:"index"`
+	}
+	if err := command.Decode(&play); err != nil || play.Playlist != playlist.Link || play.Index != 3 {
+		t.Errorf("Got play command %s, want playlist %s from index 3", command.Body, playlist.Link)
+	}
+}
+
+func TestStepVolumeUnknown(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL)
+
+	var commandErr *CommandError
+	if err := client.SendVolumeUp(); !errors.As(err, &commandErr) || commandErr.Kind != StateError {
+		t.Errorf("Got error %v, want a state error", err)
+	}
+	if commands := server.Commands(); len(commands) != 0 {
+		t.Errorf("Got %d commands, want none", len(commands))
+	}
+}
+
+func TestServerUnreachable(t *testing.T) {
+	server := fakeserver.New()
+	client := NewClient(server.URL)
+	server.Close()
+
+	var commandErr *CommandError
+	if err := client.SendNext(); !errors.As(err, &commandErr) || commandErr.Kind != NetworkError {
+		t.Errorf("Got error %v, want a network error", err)
+	}
+}
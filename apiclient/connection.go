@@ -0,0 +1,97 @@
+package apiclient
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+type ConnectionState int64
+
+const (
+	Disconnected ConnectionState = iota
+	Connecting
+	Connected
+	Backoff // Waiting until RetryAt before trying to connect again
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Backoff:
+		return "backoff"
+	}
+	return "???"
+}
+
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 60 * time.Second
+	backoffJitter  = 0.2 // Delays are randomised by up to this fraction either way
+)
+
+// nextBackoff doubles the previous delay, up to maxBackoff
+func nextBackoff(previous time.Duration) time.Duration {
+	if previous == 0 {
+		return initialBackoff
+	}
+	return min(2*previous, maxBackoff)
+}
+
+// jitter spreads out the reconnection attempts from several clients that
+// lost their connection at the same moment (e.g. a server restart)
+func jitter(delay time.Duration) time.Duration {
+	return time.Duration(float64(delay) * (1 + backoffJitter*(2*rand.Float64()-1)))
+}
+
+func (client *Client) setState(state ConnectionState, retryAt time.Time) {
+	client.mutex.Lock()
+	client.state = state
+	client.retryAt = retryAt
+	client.mutex.Unlock()
+	if client.OnStateChange != nil {
+		client.OnStateChange(state, retryAt)
+	}
+}
+
+// Run maintains the websocket connection to the server, passing every status
+// update to showNowPlaying, until ctx is cancelled. It should be run in its
+// own goroutine. If the connection drops, it tries to reconnect immediately;
+// if that fails, it retries with exponential backoff.
+func (client *Client) Run(ctx context.Context, showNowPlaying func(NowPlaying)) {
+	var backoff time.Duration
+	for ctx.Err() == nil {
+		client.setState(Connecting, time.Time{})
+		conn, err := client.dialWS(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			backoff = nextBackoff(backoff)
+			delay := jitter(backoff)
+			logger.Warn("Failed to connect", "host", client.Host, "retryIn", delay.Round(100*time.Millisecond), "err", err)
+			client.setState(Backoff, time.Now().Add(delay))
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		backoff = 0
+		client.setState(Connected, time.Time{})
+		client.Metrics.Connected()
+		// Unblock handleWsMessages if we're cancelled while it's waiting for a message
+		stopWatching := context.AfterFunc(ctx, func() { conn.Close() })
+		client.handleWsMessages(conn, showNowPlaying)
+		stopWatching()
+		client.Metrics.Disconnected()
+		client.setState(Disconnected, time.Time{})
+	}
+	client.setState(Disconnected, time.Time{})
+}
@@ -0,0 +1,267 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"nsw42/piju-touchscreen-go/fakeserver"
+)
+
+// stateChange is a call to Client.OnStateChange, and when it happened
+type stateChange struct {
+	state   ConnectionState
+	retryAt time.Time
+	at      time.Time
+}
+
+const stateTimeout = 5 * time.Second
+
+// runClient runs client against server until the test finishes, returning
+// the connection state changes it goes through
+func runClient(t *testing.T, client *Client) <-chan stateChange {
+	changes := make(chan stateChange, 100)
+	client.OnStateChange = func(state ConnectionState, retryAt time.Time) {
+		changes <- stateChange{state: state, retryAt: retryAt, at: time.Now()}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx, func(NowPlaying) {})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return changes
+}
+
+// expectStates checks that the next state changes are exactly want
+func expectStates(t *testing.T, changes <-chan stateChange, want ...ConnectionState) []stateChange {
+	t.Helper()
+	got := make([]stateChange, 0, len(want))
+	for _, state := range want {
+		select {
+		case change := <-changes:
+			if change.state != state {
+				t.Fatalf("Got state %v, want %v", change.state, state)
+			}
+			got = append(got, change)
+		case <-time.After(stateTimeout):
+			t.Fatalf("Timed out waiting for state %v", state)
+		}
+	}
+	return got
+}
+
+// waitForState skips state changes until one to want, after the given time
+func waitForState(t *testing.T, changes <-chan stateChange, want ConnectionState, after time.Time) {
+	t.Helper()
+	deadline := time.After(stateTimeout)
+	for {
+		select {
+		case change := <-changes:
+			if change.state == want && change.at.After(after) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Timed out waiting for state %v", want)
+		}
+	}
+}
+
+// collectNowPlaying returns a callback for Run or Replay that passes each
+// status it's given to the returned channel
+func collectNowPlaying() (func(NowPlaying), <-chan NowPlaying) {
+	statuses := make(chan NowPlaying, 100)
+	return func(nowPlaying NowPlaying) { statuses <- nowPlaying }, statuses
+}
+
+// nextNowPlaying waits for the next status passed to a collectNowPlaying callback
+func nextNowPlaying(t *testing.T, statuses <-chan NowPlaying) NowPlaying {
+	t.Helper()
+	select {
+	case nowPlaying := <-statuses:
+		return nowPlaying
+	case <-time.After(stateTimeout):
+		t.Fatal("Timed out waiting for a status")
+		return NowPlaying{}
+	}
+}
+
+// watchNowPlaying runs client until the test finishes, returning the
+// statuses it passes to showNowPlaying
+func watchNowPlaying(t *testing.T, client *Client) <-chan NowPlaying {
+	showNowPlaying, statuses := collectNowPlaying()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx, showNowPlaying)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return statuses
+}
+
+func newFakeServer(t *testing.T) *fakeserver.Server {
+	server := fakeserver.New()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNextBackoff(t *testing.T) {
+	var backoff time.Duration
+	for _, want := range []time.Duration{1, 2, 4, 8, 16, 32, 60, 60} {
+		backoff = nextBackoff(backoff)
+		if backoff != want*time.Second {
+			t.Errorf("Got backoff %v, want %v", backoff, want*time.Second)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL)
+	changes := runClient(t, client)
+	expectStates(t, changes, Connecting, Connected)
+
+	// A dropped connection is retried straight away
+	server.DisconnectClients()
+	expectStates(t, changes, Disconnected, Connecting, Connected)
+	if server.Clients() != 1 {
+		t.Errorf("Got %d clients, want 1", server.Clients())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	server := newFakeServer(t)
+	server.InjectFault("/ws", fakeserver.Fault{StatusCode: http.StatusServiceUnavailable, Times: 2})
+	client := NewClient(server.URL)
+	changes := runClient(t, client)
+
+	got := expectStates(t, changes, Connecting, Backoff, Connecting, Backoff, Connecting, Connected)
+	for i, backoff := range []time.Duration{initialBackoff, 2 * initialBackoff} {
+		change := got[2*i+1]
+		delay := change.retryAt.Sub(change.at)
+		minDelay := time.Duration(float64(backoff)*(1-backoffJitter)) - 100*time.Millisecond
+		maxDelay := time.Duration(float64(backoff) * (1 + backoffJitter))
+		if delay < minDelay || delay > maxDelay {
+			t.Errorf("Attempt %d: got delay %v, want %v-%v", i+1, delay, minDelay, maxDelay)
+		}
+		if next := got[2*i+2].at; next.Before(change.retryAt) {
+			t.Errorf("Attempt %d: retried %v early", i+2, change.retryAt.Sub(next))
+		}
+	}
+}
+
+func TestStaleConnection(t *testing.T) {
+	server := newFakeServer(t)
+	server.SetIgnorePings(true)
+	client := NewClient(server.URL)
+	client.StaleTimeout = 300 * time.Millisecond
+	changes := runClient(t, client)
+	expectStates(t, changes, Connecting, Connected)
+
+	// A server that doesn't answer pings is treated as gone
+	waitForState(t, changes, Disconnected, time.Time{})
+	if status := client.PlayerStatus(); status != Error {
+		t.Errorf("Got player status %v, want error", status)
+	}
+
+	// Once it answers again, a new connection stays up
+	server.SetIgnorePings(false)
+	waitForState(t, changes, Connected, time.Now())
+	select {
+	case change := <-changes:
+		t.Errorf("Got unexpected state %v", change.state)
+	case <-time.After(3 * client.StaleTimeout):
+	}
+}
+
+func TestScriptedStatuses(t *testing.T) {
+	volume := 50
+	status := func(playerStatus string, title string) fakeserver.Status {
+		status := fakeserver.Status{ApiVersion: "7.0", PlayerStatus: playerStatus, WorkerStatus: "Idle", PlayerVolume: &volume}
+		if title != "" {
+			status.CurrentTrack = fakeserver.Track{Artist: "Artist", Title: title}
+		}
+		return status
+	}
+	// Without ApiVersion or WorkerStatus, so only accepted by Lenient
+	lenientOnly := fakeserver.Status{PlayerStatus: "playing", CurrentTrack: fakeserver.Track{Title: "Lenient"}, PlayerVolume: &volume}
+
+	for _, mode := range []DecodeMode{Lenient, Strict} {
+		t.Run(mode.String(), func(t *testing.T) {
+			server := newFakeServer(t)
+			server.SetStatus(status("stopped", ""))
+			client := NewClient(server.URL)
+			client.DecodeMode = mode
+			statuses := watchNowPlaying(t, client)
+			if nowPlaying := nextNowPlaying(t, statuses); nowPlaying.Status != Stopped {
+				t.Fatalf("Got initial status %v, want stopped", nowPlaying.Status)
+			}
+
+			done := server.PlayScript([]fakeserver.Step{
+				{After: 10 * time.Millisecond, Status: status("playing", "First")},
+				{After: 10 * time.Millisecond, Status: lenientOnly},
+			})
+			select {
+			case <-done:
+			case <-time.After(stateTimeout):
+				t.Fatal("Timed out waiting for the script")
+			}
+			// Invalid in every mode
+			server.SetRawStatus(`{"PlayerStatus": "playing"`)
+			server.SetRawStatus(`{"PlayerStatus": "rewinding", "CurrentTrack": {}}`)
+			server.SetStatus(status("paused", "Last"))
+
+			want := []string{"First", "Lenient", "Last"}
+			if mode == Strict {
+				want = []string{"First", "Last"}
+			}
+			for _, title := range want {
+				if nowPlaying := nextNowPlaying(t, statuses); nowPlaying.TrackName != title {
+					t.Fatalf("Got track %q, want %q", nowPlaying.TrackName, title)
+				}
+			}
+			if status := client.PlayerStatus(); status != Paused {
+				t.Errorf("Got player status %v, want paused", status)
+			}
+		})
+	}
+}
+
+func TestCommandEffect(t *testing.T) {
+	server := newFakeServer(t)
+	volume := 50
+	playing := fakeserver.Status{ApiVersion: "7.0", PlayerStatus: "playing", WorkerStatus: "Idle", CurrentTrack: fakeserver.Track{Title: "Title"}, PlayerVolume: &volume}
+	server.SetStatus(playing)
+	server.OnCommand = func(command fakeserver.Command) {
+		if command.Path == "/player/pause" {
+			paused := playing
+			paused.PlayerStatus = "paused"
+			server.SetStatus(paused)
+		}
+	}
+	client := NewClient(server.URL)
+	statuses := watchNowPlaying(t, client)
+	if nowPlaying := nextNowPlaying(t, statuses); nowPlaying.Status != Playing {
+		t.Fatalf("Got initial status %v, want playing", nowPlaying.Status)
+	}
+
+	// The server's reply to a command arrives over the websocket
+	if err := client.SendPause(); err != nil {
+		t.Fatal(err)
+	}
+	if nowPlaying := nextNowPlaying(t, statuses); nowPlaying.Status != Paused {
+		t.Errorf("Got status %v after pausing, want paused", nowPlaying.Status)
+	}
+	if status := client.PlayerStatus(); status != Paused {
+		t.Errorf("Got player status %v, want paused", status)
+	}
+}
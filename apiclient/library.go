@@ -0,0 +1,80 @@
+package apiclient
+
+import "strings"
+
+// Artist is an entry in the server's list of artists
+type Artist struct {
+	Name string `json:"name"`
+	Link string `json:"link"` // Server-relative link to the artist's albums
+}
+
+// Album is an album in the server's library. Tracks is only set by GetAlbum.
+type Album struct {
+	Link    string  `json:"link"` // Server-relative link to the album, as used by SendPlayAlbum
+	Title   string  `json:"title"`
+	Artist  string  `json:"artist"`
+	Artwork string  `json:"artwork"` // Server-relative artwork URI, if there is any
+	Tracks  []Track `json:"tracks"`
+}
+
+// Track is a track on an album
+type Track struct {
+	Link        string  `json:"link"` // Server-relative link to the track, as used by SendPlayAlbumFromTrack
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	Album       string  `json:"album"` // Only set in search results
+	TrackNumber int     `json:"tracknumber"`
+	Duration    float64 `json:"duration"` // Seconds
+}
+
+// GetArtists returns every artist in the library, sorted by the server
+func (client *Client) GetArtists() ([]Artist, error) {
+	var artists []Artist
+	if err := client.getJson("artists/", &artists, "get artists"); err != nil {
+		return nil, err
+	}
+	return artists, nil
+}
+
+// GetArtistAlbums returns the albums by the given artist, without their tracks
+func (client *Client) GetArtistAlbums(artist Artist) ([]Album, error) {
+	var albums []Album
+	if err := client.getJson(linkToUriSuffix(artist.Link), &albums, "get albums"); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// GetAlbum returns the album at the given link, including its tracks
+func (client *Client) GetAlbum(link string) (Album, error) {
+	var album Album
+	if err := client.getJson(linkToUriSuffix(link)+"?tracks=all", &album, "get album"); err != nil {
+		return Album{}, err
+	}
+	return album, nil
+}
+
+// SendPlayAlbum replaces the queue with the given album, and plays it from
+// the start
+func (client *Client) SendPlayAlbum(album Album) error {
+	data := map[string]string{
+		"album": album.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play album")
+}
+
+// SendPlayAlbumFromTrack replaces the queue with the given album, and plays
+// it from the given track
+func (client *Client) SendPlayAlbumFromTrack(album Album, track Track) error {
+	data := map[string]string{
+		"album": album.Link,
+		"track": track.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play track")
+}
+
+// linkToUriSuffix converts a server-relative link, e.g. "/albums/3", to a
+// suffix to be appended to Host
+func linkToUriSuffix(link string) string {
+	return strings.TrimPrefix(link, "/")
+}
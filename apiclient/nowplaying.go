@@ -1,16 +1,62 @@
 package apiclient
 
+import "time"
+
 type NowPlaying struct {
 	Status      Status // If this is Error, no other values in the struct can be relied upon
+	ApiVersion  string // As reported by the server, or "" if it didn't
 	IsTrack     bool
 	ArtistName  string
 	TrackName   string
+	AlbumName   string
 	StreamName  string
 	TrackNumber int
 	AlbumTracks int
 	ArtworkUri  string
 	Artwork     []byte
 	Scanning    bool
+	Volume      int // 0-100, or UnknownVolume if the server didn't report it
+	Shuffle     Mode
+	Repeat      Mode
+
+	Duration      time.Duration // Zero if unknown, e.g. for streams
+	Position      time.Duration // Playback position when the status was received
+	PositionKnown bool          // Whether the server reported Position, rather than it defaulting to zero
+	PositionTime  time.Time     // When the status was received
+}
+
+const UnknownVolume = -1
+
+// Mode is the state of a player option that can be switched on or off
+type Mode int
+
+const (
+	ModeUnknown Mode = iota // The server didn't report it
+	ModeOff
+	ModeOn
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeUnknown:
+		return "unknown"
+	case ModeOff:
+		return "off"
+	case ModeOn:
+		return "on"
+	}
+	return "???"
+}
 
-	// TODO Volume   int
+// ElapsedAt estimates the playback position at time t, by interpolating
+// from the position reported by the server
+func (nowPlaying NowPlaying) ElapsedAt(t time.Time) time.Duration {
+	elapsed := nowPlaying.Position
+	if nowPlaying.Status == Playing && !nowPlaying.PositionTime.IsZero() {
+		elapsed += t.Sub(nowPlaying.PositionTime)
+	}
+	if nowPlaying.Duration > 0 {
+		elapsed = min(elapsed, nowPlaying.Duration)
+	}
+	return max(elapsed, 0)
 }
@@ -0,0 +1,47 @@
+package apiclient
+
+// Playlist is a playlist on the server. Tracks is only set by GetPlaylist.
+type Playlist struct {
+	Link   string  `json:"link"` // Server-relative link to the playlist, as used by SendPlayPlaylist
+	Title  string  `json:"title"`
+	Tracks []Track `json:"tracks"`
+}
+
+// GetPlaylists returns every playlist on the server, without their tracks
+func (client *Client) GetPlaylists() ([]Playlist, error) {
+	var playlists []Playlist
+	if err := client.getJson("playlists/", &playlists, "get playlists"); err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// GetPlaylist returns the playlist at the given link, including its tracks
+func (client *Client) GetPlaylist(link string) (Playlist, error) {
+	var playlist Playlist
+	if err := client.getJson(linkToUriSuffix(link)+"?tracks=all", &playlist, "get playlist"); err != nil {
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+// SendPlayPlaylist replaces the queue with the given playlist, and plays it
+// from the start
+func (client *Client) SendPlayPlaylist(playlist Playlist) error {
+	data := map[string]string{
+		"playlist": playlist.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play "+playlist.Title)
+}
+
+// SendPlayPlaylistFromTrack replaces the queue with the given playlist, and
+// plays it from the track at the given index in playlist.Tracks. The track is
+// identified by its index, rather than its link, because a playlist may
+// contain the same track more than once.
+func (client *Client) SendPlayPlaylistFromTrack(playlist Playlist, index int) error {
+	data := map[string]any{
+		"playlist": playlist.Link,
+		"index":    index,
+	}
+	return client.SendJsonCommand("player/play", data, "play track")
+}
@@ -0,0 +1,29 @@
+package apiclient
+
+// QueueEntry is a track in the server's play queue
+type QueueEntry struct {
+	QueuePos int    `json:"queuepos"` // Position in the queue, as used by SendPlayQueuePos
+	Link     string `json:"link"`     // Server-relative link to the track
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Artwork  string `json:"artwork"` // Server-relative artwork URI, if there is any
+}
+
+// GetQueue returns the tracks in the play queue, in the order they will be
+// played, starting with the current track
+func (client *Client) GetQueue() ([]QueueEntry, error) {
+	var queue []QueueEntry
+	if err := client.getJson("queue/", &queue, "get queue"); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// SendPlayQueuePos jumps playback to the given position in the play queue
+func (client *Client) SendPlayQueuePos(queuePos int) error {
+	data := map[string]int{
+		"queuepos": queuePos,
+	}
+	return client.SendJsonCommand("player/play", data, "play from queue")
+}
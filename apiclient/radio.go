@@ -0,0 +1,26 @@
+package apiclient
+
+// RadioStation is a radio station known to the server
+type RadioStation struct {
+	Name    string `json:"name"` // As reported in NowPlaying.StreamName while it's playing
+	Link    string `json:"link"` // Server-relative link to the station, as used by SendPlayRadioStation
+	Artwork string `json:"artwork"`
+}
+
+// GetRadioStations returns the radio stations known to the server, in the
+// order the server lists them
+func (client *Client) GetRadioStations() ([]RadioStation, error) {
+	var stations []RadioStation
+	if err := client.getJson("radio/", &stations, "get radio stations"); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+// SendPlayRadioStation starts playing the given radio station
+func (client *Client) SendPlayRadioStation(station RadioStation) error {
+	data := map[string]string{
+		"radio": station.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play "+station.Name)
+}
@@ -0,0 +1,164 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of record in a session file
+const (
+	recordMessage = "message"
+	recordArtwork = "artwork"
+)
+
+// record is one line of a session file
+type record struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message,omitempty"` // The raw websocket message, for recordMessage
+	URI     string    `json:"uri,omitempty"`     // For recordArtwork
+	Artwork []byte    `json:"artwork,omitempty"` // For recordArtwork
+}
+
+// Recorder writes every websocket message a Client receives, and the
+// artwork it fetches, to a JSONL file that can be passed to Replay. It is
+// safe for concurrent use, so may be shared between clients.
+type Recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewRecorder creates a new session file in dir, named after the current time
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "session-"+time.Now().Format("20060102-150405")+".jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Recording session", "path", path)
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (recorder *Recorder) write(rec record) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.encoder == nil {
+		// Closed
+		return
+	}
+	if err := recorder.encoder.Encode(rec); err != nil {
+		logger.Error("Error recording session", "err", err)
+	}
+}
+
+func (recorder *Recorder) recordMessage(message []byte) {
+	recorder.write(record{Time: time.Now(), Kind: recordMessage, Message: string(message)})
+}
+
+func (recorder *Recorder) recordArtwork(uri string, artwork []byte) {
+	recorder.write(record{Time: time.Now(), Kind: recordArtwork, URI: uri, Artwork: artwork})
+}
+
+// Close finishes the session file. Anything recorded afterwards is ignored.
+func (recorder *Recorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.encoder = nil
+	return recorder.file.Close()
+}
+
+// maxRecordSize limits the length of a line in a session file, which must
+// allow for artwork
+const maxRecordSize = 64 * 1024 * 1024
+
+// Replay feeds the messages in a session file written by a Recorder through
+// the same decoding as live messages, passing each resulting status to
+// showNowPlaying. Messages are replayed at their original pace, multiplied
+// by speed, until the file ends or ctx is cancelled. The client's state is
+// Connected while the replay is running.
+func (client *Client) Replay(ctx context.Context, path string, speed float64, showNowPlaying func(NowPlaying)) error {
+	records, err := readSession(path)
+	if err != nil {
+		return err
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+
+	// Artwork is recorded after the message that refers to it, so must all
+	// be known before replay starts
+	artwork := map[string][]byte{}
+	var messages []record
+	for _, rec := range records {
+		switch rec.Kind {
+		case recordMessage:
+			messages = append(messages, rec)
+		case recordArtwork:
+			artwork[rec.URI] = rec.Artwork
+		}
+	}
+	client.mutex.Lock()
+	client.replayArtwork = artwork
+	client.mutex.Unlock()
+
+	client.setState(Connected, time.Time{})
+	defer client.setState(Disconnected, time.Time{})
+	start := time.Now()
+	for _, rec := range messages {
+		offset := time.Duration(float64(rec.Time.Sub(messages[0].Time)) / speed)
+		timer := time.NewTimer(time.Until(start.Add(offset)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		status, err := client.statusFromReader(strings.NewReader(rec.Message))
+		if err != nil {
+			logger.Warn("Ignoring recorded status update", "err", err)
+			continue
+		}
+		client.setPlayerState(status.Status, status.Volume)
+		showNowPlaying(status)
+	}
+	logger.Info("Replay finished")
+	return nil
+}
+
+func readSession(path string) ([]record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxRecordSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			logger.Warn("Ignoring invalid record", "path", path, "line", lineNumber, "err", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no records in " + path)
+	}
+	return records, nil
+}
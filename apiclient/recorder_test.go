@@ -0,0 +1,187 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nsw42/piju-touchscreen-go/fakeserver"
+)
+
+// writeSession writes a session file containing the given lines
+func writeSession(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encodeRecord(t *testing.T, rec record) string {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestRecordReplay(t *testing.T) {
+	server := newFakeServer(t)
+	artwork := bytes.Repeat([]byte{'r'}, testArtworkSize)
+	server.SetArtwork("/artwork/1", artwork, "")
+
+	recorder, err := NewRecorder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(server.URL)
+	client.Recorder = recorder
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	showNowPlaying, statuses := collectNowPlaying()
+	go func() {
+		defer close(done)
+		client.Run(ctx, showNowPlaying)
+	}()
+
+	// The server sends its status on connect, then the playing track
+	var live []NowPlaying
+	live = append(live, nextNowPlaying(t, statuses))
+	volume := 40
+	server.SetStatus(fakeserver.Status{
+		ApiVersion:     "7.0",
+		PlayerStatus:   "playing",
+		WorkerStatus:   "Idle",
+		CurrentTrack:   fakeserver.Track{Artist: "Artist", Title: "Title", Album: "Album", Duration: 180},
+		CurrentArtwork: "/artwork/1",
+		PlayerVolume:   &volume,
+	})
+	live = append(live, nextNowPlaying(t, statuses))
+	server.SetRawStatus(`{"PlayerStatus": "rewinding"}`)
+	server.SetStatus(fakeserver.Status{ApiVersion: "7.0", PlayerStatus: "paused", WorkerStatus: "Idle", PlayerVolume: &volume})
+	live = append(live, nextNowPlaying(t, statuses))
+	cancel()
+	<-done
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(live[1].Artwork, artwork) {
+		t.Fatal("Live status has the wrong artwork")
+	}
+
+	// Damage the session file, as if it had been edited, or written by a
+	// touchscreen that crashed
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(recorder.file.Name()), "session-*.jsonl"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("Got session files %v, err %v", paths, err)
+	}
+	raw, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		lines = append(lines, line, "", "not json", `{"kind": "message", "message": 7}`)
+	}
+	lines = append(lines, `{"time": "2024-01-01T00:00:00Z", "kind": "mess`)
+	path := writeSession(t, lines...)
+
+	// Only the statuses that could be decoded are replayed, with the
+	// recorded artwork
+	replayClient := NewClient("http://replay.invalid/")
+	showNowPlaying, statuses = collectNowPlaying()
+	if err := replayClient.Replay(context.Background(), path, 100, showNowPlaying); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range live {
+		got := nextNowPlaying(t, statuses)
+		if got.Status != want.Status || got.TrackName != want.TrackName || got.ArtistName != want.ArtistName ||
+			got.Volume != want.Volume || got.ArtworkUri != want.ArtworkUri || !bytes.Equal(got.Artwork, want.Artwork) {
+			t.Errorf("Replayed status %d is %+v, want %+v", i, got, want)
+		}
+	}
+	select {
+	case nowPlaying := <-statuses:
+		t.Errorf("Unexpected status %+v", nowPlaying)
+	default:
+	}
+	if state, _ := replayClient.State(); state != Disconnected {
+		t.Errorf("Got state %v after replay, want %v", state, Disconnected)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := range 3 {
+		lines = append(lines, encodeRecord(t, record{
+			Time:    start.Add(time.Duration(i) * time.Second),
+			Kind:    recordMessage,
+			Message: `{"PlayerStatus": "playing", "CurrentTrack": {"title": "Title"}}`,
+		}))
+	}
+	path := writeSession(t, lines...)
+
+	const speed = 10
+	client := NewClient("http://replay.invalid/")
+	var times []time.Duration
+	replayStart := time.Now()
+	err := client.Replay(context.Background(), path, speed, func(NowPlaying) {
+		times = append(times, time.Since(replayStart))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("Got %d statuses, want 3", len(times))
+	}
+	for i, at := range times {
+		// The recorded interval is a second
+		earliest := time.Duration(i) * time.Second / speed
+		if at < earliest || at > earliest+time.Second/2 {
+			t.Errorf("Status %d replayed after %v, want %v", i, at, earliest)
+		}
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	message := `{"PlayerStatus": "playing", "CurrentTrack": {"title": "Title"}}`
+	path := writeSession(t,
+		encodeRecord(t, record{Time: start, Kind: recordMessage, Message: message}),
+		encodeRecord(t, record{Time: start.Add(time.Hour), Kind: recordMessage, Message: message}))
+
+	client := NewClient("http://replay.invalid/")
+	ctx, cancel := context.WithCancel(context.Background())
+	showNowPlaying, statuses := collectNowPlaying()
+	result := make(chan error)
+	go func() {
+		result <- client.Replay(ctx, path, 1, showNowPlaying)
+	}()
+	nextNowPlaying(t, statuses)
+	cancel()
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Errorf("Got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(stateTimeout):
+		t.Fatal("Replay not cancelled")
+	}
+}
+
+func TestReplayNoRecords(t *testing.T) {
+	path := writeSession(t, "", "not json", `{"time": "2024-01-01T00:00:00Z", "kind": "mess`)
+	client := NewClient("http://replay.invalid/")
+	if err := client.Replay(context.Background(), path, 1, func(NowPlaying) {}); err == nil {
+		t.Error("Replay of a session with no valid records succeeded")
+	}
+	if err := client.Replay(context.Background(), filepath.Join(t.TempDir(), "missing.jsonl"), 1, func(NowPlaying) {}); err == nil {
+		t.Error("Replay of a missing session succeeded")
+	}
+}
@@ -0,0 +1,218 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusMessage is the schema of the status object the server returns from
+// GET / and pushes over the websocket. Fields that are pointers are ones
+// whose absence we need to be able to detect.
+//
+// To support a new server field, add it here and map it in toNowPlaying:
+// that is the only place where server fields are interpreted.
+type statusMessage struct {
+	ApiVersion        *string       `json:"ApiVersion"`
+	PlayerStatus      *string       `json:"PlayerStatus"`
+	WorkerStatus      *string       `json:"WorkerStatus"`
+	CurrentTrack      *trackMessage `json:"CurrentTrack"`
+	CurrentTrackIndex int           `json:"CurrentTrackIndex"`
+	MaximumTrackIndex int           `json:"MaximumTrackIndex"`
+	CurrentArtwork    string        `json:"CurrentArtwork"`
+	CurrentStream     string        `json:"CurrentStream"`
+	PlayerVolume      *int          `json:"PlayerVolume"`
+	Shuffle           *bool         `json:"Shuffle"`
+	Repeat            *bool         `json:"Repeat"`
+	// Playback position within the current track, in seconds
+	CurrentTrackPosition *float64 `json:"CurrentTrackPosition"`
+}
+
+// trackMessage is the schema of the CurrentTrack object within a status
+// message. The server sends an empty object if there is no current track.
+type trackMessage struct {
+	Artist *string `json:"artist"`
+	Title  *string `json:"title"`
+	Album  *string `json:"album"`
+	// Length of the track, in seconds
+	Duration *float64 `json:"duration"`
+}
+
+func (track *trackMessage) isEmpty() bool {
+	return track == nil || (track.Artist == nil && track.Title == nil && track.Album == nil)
+}
+
+// DecodeMode determines how tolerant the client is of status messages that
+// don't fully match the expected schema. Malformed JSON, values of the wrong
+// type and unrecognised player states are rejected in all modes.
+type DecodeMode int
+
+const (
+	// Lenient fills in defaults for any missing optional fields
+	Lenient DecodeMode = iota
+	// Strict additionally requires every field that the server is expected
+	// to send
+	Strict
+)
+
+// SupportedApiMajorVersion is the major version of the server API that this
+// client understands. Strict mode rejects status messages from any other.
+const SupportedApiMajorVersion = 7
+
+func (m DecodeMode) String() string {
+	switch m {
+	case Lenient:
+		return "lenient"
+	case Strict:
+		return "strict"
+	}
+	return "???"
+}
+
+// StatusError describes why a status message from the server was rejected
+type StatusError struct {
+	Field  string
+	Reason string
+}
+
+func (e *StatusError) Error() string {
+	if e.Field == "" {
+		return "invalid status message: " + e.Reason
+	}
+	return "invalid status message: " + e.Field + ": " + e.Reason
+}
+
+func decodeStatusMessage(reader io.Reader, mode DecodeMode) (*statusMessage, error) {
+	msg := &statusMessage{}
+	if err := json.NewDecoder(reader).Decode(msg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &StatusError{typeErr.Field, "expected " + typeErr.Type.String() + ", got " + typeErr.Value}
+		}
+		return nil, &StatusError{"", err.Error()}
+	}
+	if err := msg.validate(mode); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (msg *statusMessage) validate(mode DecodeMode) error {
+	if msg.PlayerStatus == nil {
+		return &StatusError{"PlayerStatus", "missing"}
+	}
+	if _, err := parsePlayerStatus(*msg.PlayerStatus); err != nil {
+		return err
+	}
+	if msg.PlayerVolume != nil && (*msg.PlayerVolume < 0 || *msg.PlayerVolume > 100) {
+		return &StatusError{"PlayerVolume", fmt.Sprintf("out of range: %d", *msg.PlayerVolume)}
+	}
+	if msg.CurrentTrackPosition != nil && *msg.CurrentTrackPosition < 0 {
+		return &StatusError{"CurrentTrackPosition", "negative"}
+	}
+	if mode == Strict {
+		missing := "missing, in " + mode.String() + " mode"
+		if msg.ApiVersion == nil || *msg.ApiVersion == "" {
+			return &StatusError{"ApiVersion", missing}
+		}
+		if major, ok := apiMajorVersion(*msg.ApiVersion); !ok || major != SupportedApiMajorVersion {
+			return &StatusError{"ApiVersion", fmt.Sprintf("unsupported version %q, in %s mode", *msg.ApiVersion, mode)}
+		}
+		if msg.WorkerStatus == nil {
+			return &StatusError{"WorkerStatus", missing}
+		}
+		if msg.PlayerVolume == nil {
+			return &StatusError{"PlayerVolume", missing}
+		}
+		if msg.CurrentTrack == nil {
+			return &StatusError{"CurrentTrack", missing}
+		}
+		if !msg.CurrentTrack.isEmpty() {
+			if msg.CurrentTrack.Artist == nil {
+				return &StatusError{"CurrentTrack.artist", missing}
+			}
+			if msg.CurrentTrack.Title == nil {
+				return &StatusError{"CurrentTrack.title", missing}
+			}
+		}
+	}
+	return nil
+}
+
+// apiMajorVersion returns the major version from a version such as "7.0"
+func apiMajorVersion(version string) (int, bool) {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	return n, err == nil
+}
+
+func parsePlayerStatus(statStr string) (Status, error) {
+	switch statStr {
+	case "stopped":
+		return Stopped, nil
+	case "playing":
+		return Playing, nil
+	case "paused":
+		return Paused, nil
+	}
+	return Error, &StatusError{"PlayerStatus", fmt.Sprintf("unrecognised value %q", statStr)}
+}
+
+// toNowPlaying maps a validated status message, received at the given time,
+// onto a NowPlaying. Artwork is not fetched here: only the URI is filled in.
+func (msg *statusMessage) toNowPlaying(received time.Time) NowPlaying {
+	stat := NowPlaying{}
+	stat.Status, _ = parsePlayerStatus(*msg.PlayerStatus)
+	stat.ApiVersion = stringOrDefault(msg.ApiVersion, "")
+	if !msg.CurrentTrack.isEmpty() {
+		stat.IsTrack = true
+		stat.ArtistName = stringOrDefault(msg.CurrentTrack.Artist, "Unknown artist")
+		stat.TrackName = stringOrDefault(msg.CurrentTrack.Title, "Unknown track")
+		stat.AlbumName = stringOrDefault(msg.CurrentTrack.Album, "")
+		stat.Duration = secondsToDuration(msg.CurrentTrack.Duration)
+	}
+	stat.Position = secondsToDuration(msg.CurrentTrackPosition)
+	stat.PositionKnown = msg.CurrentTrackPosition != nil
+	stat.PositionTime = received
+	stat.StreamName = msg.CurrentStream
+	stat.TrackNumber = msg.CurrentTrackIndex
+	stat.AlbumTracks = msg.MaximumTrackIndex
+	stat.ArtworkUri = msg.CurrentArtwork
+	if msg.PlayerVolume != nil {
+		stat.Volume = *msg.PlayerVolume
+	} else {
+		stat.Volume = UnknownVolume
+	}
+	stat.Shuffle = boolToMode(msg.Shuffle)
+	stat.Repeat = boolToMode(msg.Repeat)
+	stat.Scanning = msg.WorkerStatus != nil && strings.ToLower(*msg.WorkerStatus) != "idle"
+	return stat
+}
+
+func stringOrDefault(s *string, defaultVal string) string {
+	if s == nil {
+		return defaultVal
+	}
+	return *s
+}
+
+func boolToMode(on *bool) Mode {
+	switch {
+	case on == nil:
+		return ModeUnknown
+	case *on:
+		return ModeOn
+	}
+	return ModeOff
+}
+
+func secondsToDuration(seconds *float64) time.Duration {
+	if seconds == nil {
+		return 0
+	}
+	return time.Duration(*seconds * float64(time.Second))
+}
@@ -0,0 +1,129 @@
+package apiclient
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fullStatus = `{
+	"ApiVersion": "7.0",
+	"PlayerStatus": "playing",
+	"WorkerStatus": "Idle",
+	"CurrentTrack": {"artist": "Artist", "title": "Title", "album": "Album", "duration": 200},
+	"CurrentTrackIndex": 3,
+	"MaximumTrackIndex": 10,
+	"CurrentArtwork": "/artwork/1",
+	"PlayerVolume": 50,
+	"CurrentTrackPosition": 12.5,
+	"Shuffle": true,
+	"Repeat": false
+}`
+
+func TestDecodeStatusMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		json   string
+		strict string // The field Strict rejects, or "" to accept
+		// The field Lenient rejects, or "" to accept. Malformed JSON is
+		// rejected with no field, so is marked "-".
+		lenient string
+	}{
+		{name: "complete", json: fullStatus},
+		{name: "malformed JSON", json: `{"PlayerStatus": "playing"`, strict: "-", lenient: "-"},
+		{name: "not an object", json: `[]`, strict: "-", lenient: "-"},
+		{name: "wrong type", json: `{"PlayerStatus": "playing", "PlayerVolume": "loud"}`, strict: "PlayerVolume", lenient: "PlayerVolume"},
+		{name: "wrong type in track", json: `{"PlayerStatus": "playing", "CurrentTrack": {"title": 7}}`, strict: "CurrentTrack.title", lenient: "CurrentTrack.title"},
+		{name: "missing PlayerStatus", json: `{"PlayerVolume": 50}`, strict: "PlayerStatus", lenient: "PlayerStatus"},
+		{name: "unknown PlayerStatus", json: `{"PlayerStatus": "rewinding"}`, strict: "PlayerStatus", lenient: "PlayerStatus"},
+		{name: "volume too low", json: `{"PlayerStatus": "playing", "PlayerVolume": -1}`, strict: "PlayerVolume", lenient: "PlayerVolume"},
+		{name: "volume too high", json: `{"PlayerStatus": "playing", "PlayerVolume": 101}`, strict: "PlayerVolume", lenient: "PlayerVolume"},
+		{name: "negative position", json: `{"PlayerStatus": "playing", "CurrentTrackPosition": -0.5}`, strict: "CurrentTrackPosition", lenient: "CurrentTrackPosition"},
+		{name: "missing ApiVersion", json: `{"PlayerStatus": "stopped", "WorkerStatus": "Idle", "PlayerVolume": 50, "CurrentTrack": {}}`, strict: "ApiVersion"},
+		{name: "unsupported ApiVersion", json: `{"ApiVersion": "8.1", "PlayerStatus": "stopped", "WorkerStatus": "Idle", "PlayerVolume": 50, "CurrentTrack": {}}`, strict: "ApiVersion"},
+		{name: "missing WorkerStatus", json: `{"ApiVersion": "7.0", "PlayerStatus": "stopped", "PlayerVolume": 50, "CurrentTrack": {}}`, strict: "WorkerStatus"},
+		{name: "missing PlayerVolume", json: `{"ApiVersion": "7.0", "PlayerStatus": "stopped", "WorkerStatus": "Idle", "CurrentTrack": {}}`, strict: "PlayerVolume"},
+		{name: "missing CurrentTrack", json: `{"ApiVersion": "7.0", "PlayerStatus": "stopped", "WorkerStatus": "Idle", "PlayerVolume": 50}`, strict: "CurrentTrack"},
+		{name: "missing title", json: `{"ApiVersion": "7.0", "PlayerStatus": "playing", "WorkerStatus": "Idle", "PlayerVolume": 50, "CurrentTrack": {"artist": "Artist"}}`, strict: "CurrentTrack.title"},
+	}
+	for _, test := range tests {
+		for _, mode := range []DecodeMode{Lenient, Strict} {
+			t.Run(test.name+" "+mode.String(), func(t *testing.T) {
+				want := test.lenient
+				if mode == Strict {
+					want = test.strict
+				}
+				msg, err := decodeStatusMessage(strings.NewReader(test.json), mode)
+				if want == "" {
+					if err != nil {
+						t.Errorf("Got error %v", err)
+					} else if msg == nil {
+						t.Error("Got no message")
+					}
+					return
+				}
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) {
+					t.Fatalf("Got error %v, want a *StatusError", err)
+				}
+				if want == "-" {
+					want = ""
+				}
+				if statusErr.Field != want {
+					t.Errorf("Got error %v, want one for field %q", err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestToNowPlaying(t *testing.T) {
+	received := time.Now()
+	msg, err := decodeStatusMessage(strings.NewReader(fullStatus), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := msg.toNowPlaying(received)
+	want := NowPlaying{
+		Status:        Playing,
+		ApiVersion:    "7.0",
+		IsTrack:       true,
+		ArtistName:    "Artist",
+		TrackName:     "Title",
+		AlbumName:     "Album",
+		TrackNumber:   3,
+		AlbumTracks:   10,
+		ArtworkUri:    "/artwork/1",
+		Volume:        50,
+		Shuffle:       ModeOn,
+		Repeat:        ModeOff,
+		Duration:      200 * time.Second,
+		Position:      12500 * time.Millisecond,
+		PositionKnown: true,
+		PositionTime:  received,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+
+	// Lenient mode fills in defaults for whatever is missing
+	msg, err = decodeStatusMessage(strings.NewReader(`{"PlayerStatus": "paused", "CurrentTrack": {"album": "Album"}}`), Lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = msg.toNowPlaying(received)
+	want = NowPlaying{
+		Status:       Paused,
+		IsTrack:      true,
+		ArtistName:   "Unknown artist",
+		TrackName:    "Unknown track",
+		AlbumName:    "Album",
+		Volume:       UnknownVolume,
+		PositionTime: received,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+}
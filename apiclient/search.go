@@ -0,0 +1,35 @@
+package apiclient
+
+import "net/url"
+
+// SearchResults are the artists, albums and tracks matching a search
+type SearchResults struct {
+	Artists []Artist `json:"artists"`
+	Albums  []Album  `json:"albums"`
+	Tracks  []Track  `json:"tracks"`
+}
+
+// Search asks the server for the artists, albums and tracks matching query
+func (client *Client) Search(query string) (SearchResults, error) {
+	var results SearchResults
+	if err := client.getJson("search/"+url.PathEscape(query), &results, "search"); err != nil {
+		return SearchResults{}, err
+	}
+	return results, nil
+}
+
+// SendPlayArtist replaces the queue with every track by the given artist
+func (client *Client) SendPlayArtist(artist Artist) error {
+	data := map[string]string{
+		"artist": artist.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play "+artist.Name)
+}
+
+// SendPlayTrack replaces the queue with the given track
+func (client *Client) SendPlayTrack(track Track) error {
+	data := map[string]string{
+		"track": track.Link,
+	}
+	return client.SendJsonCommand("player/play", data, "play track")
+}
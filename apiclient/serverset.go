@@ -0,0 +1,161 @@
+package apiclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultFailoverAttempts is how many consecutive failed connection attempts
+// cause a ServerSet to fail over to the next server
+const DefaultFailoverAttempts = 3
+
+type Server struct {
+	Name string
+	Host string // The server URL, e.g. "http://192.168.1.10:5000/"
+}
+
+// ServerSet connects to one of several servers at a time, switching between
+// them on request or, if Failover is set, when the active one is unreachable.
+// Servers, Failover, FailoverAttempts, Configure and the callbacks must be set
+// before the first call to Switch, and not changed afterwards.
+type ServerSet struct {
+	Servers          []Server
+	Failover         bool
+	FailoverAttempts int
+	// Configure is called for each new Client, before it connects, to apply
+	// any settings beyond its host
+	Configure func(client *Client)
+	// OnSwitch is called whenever a different server becomes active. It is
+	// called in the same order as the switches, so must not call Switch.
+	OnSwitch       func(index int, client *Client)
+	OnStateChange  func(state ConnectionState, retryAt time.Time)
+	ShowNowPlaying func(NowPlaying)
+
+	// switchMutex is held throughout each switch, so that OnSwitch is called
+	// in the same order as the active server changes
+	switchMutex sync.Mutex
+
+	mutex    sync.Mutex
+	active   int
+	client   *Client
+	cancel   context.CancelFunc
+	failures int // Consecutive failed connection attempts to the active server
+}
+
+// Active returns the index of the active server, and the client connected to it
+func (set *ServerSet) Active() (int, *Client) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return set.active, set.client
+}
+
+// Client returns the client connected to the active server
+func (set *ServerSet) Client() *Client {
+	_, client := set.Active()
+	return client
+}
+
+// Switch disconnects from the active server, if any, and connects to the
+// server with the given index
+func (set *ServerSet) Switch(index int) {
+	set.switchMutex.Lock()
+	defer set.switchMutex.Unlock()
+	set.switchTo(index)
+}
+
+// switchTo does the work of Switch. The switchMutex must be held.
+func (set *ServerSet) switchTo(index int) {
+	if index < 0 || index >= len(set.Servers) {
+		logger.Warn("Ignoring request to switch to unknown server", "index", index)
+		return
+	}
+	server := set.Servers[index]
+	logger.Info("Switching server", "name", server.Name, "host", server.Host)
+
+	client := NewClient(server.Host)
+	if set.Configure != nil {
+		set.Configure(client)
+	}
+	client.OnStateChange = func(state ConnectionState, retryAt time.Time) {
+		set.onStateChange(client, state, retryAt)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	set.mutex.Lock()
+	if set.cancel != nil {
+		set.cancel()
+	}
+	set.active = index
+	set.client = client
+	set.cancel = cancel
+	set.failures = 0
+	set.mutex.Unlock()
+
+	if set.OnSwitch != nil {
+		set.OnSwitch(index, client)
+	}
+	go client.Run(ctx, func(nowPlaying NowPlaying) {
+		if set.isActive(client) && set.ShowNowPlaying != nil {
+			set.ShowNowPlaying(nowPlaying)
+		}
+	})
+}
+
+// PlayerStatus returns the player status of the active server, or Error if
+// there is none
+func (set *ServerSet) PlayerStatus() Status {
+	client := set.Client()
+	if client == nil {
+		return Error
+	}
+	return client.PlayerStatus()
+}
+
+func (set *ServerSet) isActive(client *Client) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return client == set.client
+}
+
+func (set *ServerSet) onStateChange(client *Client, state ConnectionState, retryAt time.Time) {
+	set.mutex.Lock()
+	if client != set.client {
+		// A server we've switched away from, shutting down
+		set.mutex.Unlock()
+		return
+	}
+	switch state {
+	case Connected:
+		set.failures = 0
+	case Backoff:
+		set.failures++
+	}
+	failOver := set.Failover && len(set.Servers) > 1 && state == Backoff && set.failures >= set.failoverAttempts()
+	next := (set.active + 1) % len(set.Servers)
+	set.mutex.Unlock()
+
+	if failOver {
+		logger.Warn("Server unreachable - failing over", "host", client.Host)
+		// Switch cancels the client that is calling us, so must not be called synchronously
+		go func() {
+			set.switchMutex.Lock()
+			defer set.switchMutex.Unlock()
+			// Unless the user has chosen another server meanwhile
+			if set.isActive(client) {
+				set.switchTo(next)
+			}
+		}()
+		return
+	}
+	if set.OnStateChange != nil {
+		set.OnStateChange(state, retryAt)
+	}
+}
+
+func (set *ServerSet) failoverAttempts() int {
+	if set.FailoverAttempts <= 0 {
+		return DefaultFailoverAttempts
+	}
+	return set.FailoverAttempts
+}
@@ -0,0 +1,123 @@
+package apiclient
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"nsw42/piju-touchscreen-go/fakeserver"
+)
+
+// recordServerSet returns the OnSwitch and OnStateChange calls made by set,
+// as "switch <index>" or the name of the state
+func recordServerSet(t *testing.T, set *ServerSet) <-chan string {
+	events := make(chan string, 100)
+	set.OnSwitch = func(index int, client *Client) {
+		events <- fmt.Sprintf("switch %d", index)
+	}
+	set.OnStateChange = func(state ConnectionState, retryAt time.Time) {
+		events <- state.String()
+	}
+	t.Cleanup(func() { stopServerSet(set) })
+	return events
+}
+
+// stopServerSet disconnects from the active server, if any
+func stopServerSet(set *ServerSet) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.cancel != nil {
+		set.cancel()
+	}
+}
+
+func expectEvents(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+	for _, event := range want {
+		select {
+		case got := <-events:
+			if got != event {
+				t.Fatalf("Got %q, want %q", got, event)
+			}
+		case <-time.After(stateTimeout):
+			t.Fatalf("Timed out waiting for %q", event)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	unreachable := newFakeServer(t)
+	unreachable.InjectFault("/ws", fakeserver.Fault{StatusCode: http.StatusServiceUnavailable})
+	reachable := newFakeServer(t)
+	set := &ServerSet{
+		Servers:          []Server{{Name: "first", Host: unreachable.URL}, {Name: "second", Host: reachable.URL}},
+		Failover:         true,
+		FailoverAttempts: 2,
+	}
+	events := recordServerSet(t, set)
+
+	// The second failure isn't reported, as it causes the failover, and
+	// nothing is heard from the first server once it has been replaced
+	set.Switch(0)
+	expectEvents(t, events, "switch 0", "connecting", "backoff", "connecting", "switch 1", "connecting", "connected")
+	if index, _ := set.Active(); index != 1 {
+		t.Errorf("Got active server %d, want 1", index)
+	}
+
+	// The count of failures starts again for the new server
+	reachable.InjectFault("/ws", fakeserver.Fault{StatusCode: http.StatusServiceUnavailable, Times: 1})
+	reachable.DisconnectClients()
+	expectEvents(t, events, "disconnected", "connecting", "backoff", "connecting", "connected")
+	select {
+	case event := <-events:
+		t.Errorf("Got unexpected %q", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNoFailover(t *testing.T) {
+	unreachable := newFakeServer(t)
+	unreachable.InjectFault("/ws", fakeserver.Fault{StatusCode: http.StatusServiceUnavailable, Times: 2})
+	set := &ServerSet{
+		Servers:          []Server{{Name: "first", Host: unreachable.URL}, {Name: "second", Host: newFakeServer(t).URL}},
+		FailoverAttempts: 1,
+	}
+	events := recordServerSet(t, set)
+
+	set.Switch(0)
+	expectEvents(t, events, "switch 0", "connecting", "backoff", "connecting", "backoff", "connecting", "connected")
+}
+
+// TestConcurrentSwitch checks that OnSwitch reports the server that ends up
+// active, however switches race
+func TestConcurrentSwitch(t *testing.T) {
+	servers := []Server{{Name: "first", Host: newFakeServer(t).URL}, {Name: "second", Host: newFakeServer(t).URL}}
+	var mutex sync.Mutex
+	var switchedTo *Client
+	set := &ServerSet{
+		Servers: servers,
+		OnSwitch: func(index int, client *Client) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switchedTo = client
+		},
+	}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set.Switch(i % 2)
+		}()
+	}
+	wg.Wait()
+	_, active := set.Active()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if switchedTo != active {
+		t.Error("OnSwitch was last called for a client that isn't active")
+	}
+	stopServerSet(set)
+}
@@ -0,0 +1,244 @@
+package artworkcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nsw42/piju-touchscreen-go/logging"
+)
+
+var logger = logging.For("artworkcache")
+
+// Validators are the values needed to make a conditional request for an
+// artwork URI, as returned by the server when it was last fetched
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Cache is a size-bounded, least-recently-used cache of artwork, keyed by
+// URI, that persists across restarts. Each entry is stored as two files:
+// the artwork itself, and a JSON file recording its URI and validators.
+// It is safe for concurrent use.
+type Cache struct {
+	dir        string
+	maxBytes   int64
+	mutex      sync.Mutex
+	entries    map[string]*entry // keyed by URI
+	totalBytes int64
+}
+
+type entry struct {
+	URI        string     `json:"uri"`
+	Validators Validators `json:"validators"`
+	size       int64
+	lastUsed   time.Time
+}
+
+const (
+	dataSuffix = ".img"
+	metaSuffix = ".json"
+)
+
+// DefaultDir returns the directory to use for the cache, following the XDG
+// base directory specification
+func DefaultDir() string {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(base, "piju-touchscreen", "artwork")
+}
+
+// New opens the cache in the given directory, creating it if necessary and
+// discarding least recently used entries until it fits within maxBytes
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cache := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*entry{},
+	}
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+	cache.mutex.Lock()
+	cache.evict()
+	cache.mutex.Unlock()
+	return cache, nil
+}
+
+func (cache *Cache) load() error {
+	// Tidy up after any write that was interrupted
+	if tmpFiles, err := filepath.Glob(filepath.Join(cache.dir, ".tmp-*")); err == nil {
+		for _, tmpFile := range tmpFiles {
+			os.Remove(tmpFile)
+		}
+	}
+
+	metaFiles, err := filepath.Glob(filepath.Join(cache.dir, "*"+metaSuffix))
+	if err != nil {
+		return err
+	}
+	for _, metaPath := range metaFiles {
+		buf, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		e := &entry{}
+		dataPath := strings.TrimSuffix(metaPath, metaSuffix) + dataSuffix
+		info, statErr := os.Stat(dataPath)
+		if json.Unmarshal(buf, e) != nil || statErr != nil || cache.pathFor(e.URI, metaSuffix) != metaPath {
+			// Corrupt or incomplete entry
+			logger.Warn("Discarding invalid artwork cache entry", "path", metaPath)
+			os.Remove(metaPath)
+			os.Remove(dataPath)
+			continue
+		}
+		e.size = info.Size()
+		e.lastUsed = info.ModTime()
+		cache.entries[e.URI] = e
+		cache.totalBytes += e.size
+	}
+	return nil
+}
+
+func (cache *Cache) pathFor(uri string, suffix string) string {
+	hash := sha256.Sum256([]byte(uri))
+	return filepath.Join(cache.dir, hex.EncodeToString(hash[:])+suffix)
+}
+
+// Get returns the cached artwork for uri, if any, along with the validators
+// to use to check whether it is still up to date
+func (cache *Cache) Get(uri string) ([]byte, Validators, bool) {
+	// Read the file with the mutex held, so that a concurrent Put can't
+	// replace it with artwork that doesn't match e's validators, and a read
+	// failure doesn't cause a fresh entry to be removed
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	e, ok := cache.entries[uri]
+	if !ok {
+		return nil, Validators{}, false
+	}
+	data, err := os.ReadFile(cache.pathFor(uri, dataSuffix))
+	if err != nil {
+		logger.Error("Error reading cached artwork", "err", err)
+		cache.remove(uri)
+		return nil, Validators{}, false
+	}
+	cache.touch(e)
+	return data, e.Validators, true
+}
+
+// touch marks e as recently used. The access time is recorded as the
+// modification time of the artwork file, so that it survives a restart. The
+// mutex must be held.
+func (cache *Cache) touch(e *entry) {
+	e.lastUsed = time.Now()
+	os.Chtimes(cache.pathFor(e.URI, dataSuffix), e.lastUsed, e.lastUsed)
+}
+
+// Put stores artwork for uri, replacing any existing entry
+func (cache *Cache) Put(uri string, data []byte, validators Validators) error {
+	if int64(len(data)) > cache.maxBytes {
+		// Would immediately be evicted, but mustn't leave the old artwork
+		// to be mistaken for this
+		cache.Remove(uri)
+		return nil
+	}
+	e := &entry{URI: uri, Validators: validators, size: int64(len(data)), lastUsed: time.Now()}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	// Write the data before the metadata, so that load never finds metadata
+	// without the corresponding data
+	if err := writeFileAtomic(cache.pathFor(uri, dataSuffix), data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(cache.pathFor(uri, metaSuffix), meta); err != nil {
+		os.Remove(cache.pathFor(uri, dataSuffix))
+		return err
+	}
+	if old, ok := cache.entries[uri]; ok {
+		cache.totalBytes -= old.size
+	}
+	cache.entries[uri] = e
+	cache.totalBytes += e.size
+	cache.evict()
+	return nil
+}
+
+// Remove discards any entry for uri
+func (cache *Cache) Remove(uri string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.remove(uri)
+}
+
+func (cache *Cache) remove(uri string) {
+	if e, ok := cache.entries[uri]; ok {
+		cache.totalBytes -= e.size
+		delete(cache.entries, uri)
+	}
+	for _, suffix := range []string{metaSuffix, dataSuffix} {
+		if err := os.Remove(cache.pathFor(uri, suffix)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Error removing cached artwork", "err", err)
+		}
+	}
+}
+
+// evict discards least recently used entries until the cache fits within
+// its size limit. The mutex must be held.
+func (cache *Cache) evict() {
+	if cache.totalBytes <= cache.maxBytes {
+		return
+	}
+	byAge := make([]*entry, 0, len(cache.entries))
+	for _, e := range cache.entries {
+		byAge = append(byAge, e)
+	}
+	sort.Slice(byAge, func(i, j int) bool { return byAge[i].lastUsed.Before(byAge[j].lastUsed) })
+	for _, e := range byAge {
+		if cache.totalBytes <= cache.maxBytes {
+			break
+		}
+		cache.remove(e.URI)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
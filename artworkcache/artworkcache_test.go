@@ -0,0 +1,131 @@
+package artworkcache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func artwork(fill byte, size int) []byte {
+	return bytes.Repeat([]byte{fill}, size)
+}
+
+func mustNew(t *testing.T, dir string, maxBytes int64) *Cache {
+	t.Helper()
+	cache, err := New(dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func mustPut(t *testing.T, cache *Cache, uri string, data []byte, validators Validators) {
+	t.Helper()
+	if err := cache.Put(uri, data, validators); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkGet(t *testing.T, cache *Cache, uri string, want []byte, wantValidators Validators) {
+	t.Helper()
+	data, validators, ok := cache.Get(uri)
+	switch {
+	case !ok:
+		t.Errorf("%s not in cache", uri)
+	case !bytes.Equal(data, want):
+		t.Errorf("%s: got %d bytes of artwork, want %d", uri, len(data), len(want))
+	case validators != wantValidators:
+		t.Errorf("%s: got validators %+v, want %+v", uri, validators, wantValidators)
+	}
+}
+
+func checkMissing(t *testing.T, cache *Cache, uri string) {
+	t.Helper()
+	if _, _, ok := cache.Get(uri); ok {
+		t.Errorf("%s unexpectedly in cache", uri)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	cache := mustNew(t, t.TempDir(), 1000)
+	checkMissing(t, cache, "/artwork/a")
+
+	first := Validators{ETag: `"1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	mustPut(t, cache, "/artwork/a", artwork('a', 100), first)
+	checkGet(t, cache, "/artwork/a", artwork('a', 100), first)
+
+	// Replacing the artwork replaces its validators too
+	second := Validators{ETag: `"2"`}
+	mustPut(t, cache, "/artwork/a", artwork('A', 200), second)
+	checkGet(t, cache, "/artwork/a", artwork('A', 200), second)
+
+	cache.Remove("/artwork/a")
+	checkMissing(t, cache, "/artwork/a")
+}
+
+func TestEviction(t *testing.T) {
+	cache := mustNew(t, t.TempDir(), 300)
+	mustPut(t, cache, "/artwork/a", artwork('a', 100), Validators{})
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, cache, "/artwork/b", artwork('b', 100), Validators{})
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, cache, "/artwork/c", artwork('c', 100), Validators{})
+	time.Sleep(10 * time.Millisecond)
+
+	// Using a makes b the least recently used, so adding d evicts b
+	checkGet(t, cache, "/artwork/a", artwork('a', 100), Validators{})
+	time.Sleep(10 * time.Millisecond)
+	mustPut(t, cache, "/artwork/d", artwork('d', 100), Validators{})
+	checkMissing(t, cache, "/artwork/b")
+	checkGet(t, cache, "/artwork/a", artwork('a', 100), Validators{})
+	checkGet(t, cache, "/artwork/c", artwork('c', 100), Validators{})
+	checkGet(t, cache, "/artwork/d", artwork('d', 100), Validators{})
+
+	// Artwork bigger than the whole cache isn't stored, and replaces any
+	// smaller artwork stored previously
+	mustPut(t, cache, "/artwork/e", artwork('e', 301), Validators{})
+	checkMissing(t, cache, "/artwork/e")
+	checkGet(t, cache, "/artwork/d", artwork('d', 100), Validators{})
+	mustPut(t, cache, "/artwork/d", artwork('D', 301), Validators{ETag: `"D"`})
+	checkMissing(t, cache, "/artwork/d")
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	cache := mustNew(t, dir, 1000)
+	validators := Validators{ETag: `"a"`}
+	mustPut(t, cache, "/artwork/a", artwork('a', 100), validators)
+	mustPut(t, cache, "/artwork/b", artwork('b', 100), Validators{ETag: `"b"`})
+	mustPut(t, cache, "/artwork/c", artwork('c', 100), Validators{ETag: `"c"`})
+
+	// Make a the most recently used, then b, then c, as recorded on disk
+	now := time.Now()
+	for i, uri := range []string{"/artwork/a", "/artwork/b", "/artwork/c"} {
+		used := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(cache.pathFor(uri, dataSuffix), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leave behind an interrupted write and an entry whose data is missing
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, cache, "/artwork/d", artwork('d', 100), Validators{})
+	if err := os.Remove(cache.pathFor("/artwork/d", dataSuffix)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening with a smaller limit keeps the most recently used entries
+	reopened := mustNew(t, dir, 200)
+	checkGet(t, reopened, "/artwork/a", artwork('a', 100), validators)
+	checkGet(t, reopened, "/artwork/b", artwork('b', 100), Validators{ETag: `"b"`})
+	checkMissing(t, reopened, "/artwork/c")
+	checkMissing(t, reopened, "/artwork/d")
+	for _, path := range []string{filepath.Join(dir, ".tmp-123"), reopened.pathFor("/artwork/d", metaSuffix)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't tidied up", path)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package controlapi provides a local HTTP API that reports what the
+// touchscreen is showing, and accepts actions, so that home automation
+// scripts can drive it directly.
+//
+// GET /status returns the current state as JSON. The actions are:
+//
+//	POST /page          {"page": "qrcode"}  show a page, e.g. the QR code
+//	POST /screen/wake                       unblank the screen
+//	POST /screen/blank                      blank the screen
+//	POST /theme/reload                      reload the stylesheet
+//	POST /server        {"index": 1}        switch server, by index or {"name": ...}
+//
+// Actions must have a Content-Type of application/json, even if they have no
+// body, and return 204 No Content if successful.
+package controlapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+	"nsw42/piju-touchscreen-go/screenblankmgr"
+)
+
+// Kiosk is the part of a frontend that can be driven through the API,
+// beyond what frontend.Frontend offers. Its methods may be called from any
+// goroutine.
+type Kiosk interface {
+	// CurrentPage returns the name of the page being shown
+	CurrentPage() string
+	// ShowPage shows the page with the given name, or returns an error if
+	// there is no such page
+	ShowPage(page string) error
+	// ReloadTheme reloads the stylesheet
+	ReloadTheme() error
+}
+
+// Server serves the API. It implements frontend.Frontend, so that it can
+// be told what the user is being shown, and should be combined with the
+// real frontend using frontend.Multi.
+type Server struct {
+	// Kiosk, if not nil, is used to report and change the page being shown,
+	// and to reload the theme
+	Kiosk Kiosk
+	// ScreenMgr, if not nil, is used to report and change whether the screen
+	// is blanked
+	ScreenMgr *screenblankmgr.ScreenBlankManager
+
+	mutex           sync.Mutex
+	nowPlaying      apiclient.NowPlaying
+	connectionState apiclient.ConnectionState
+	retryAt         time.Time
+	searching       bool
+	servers         []apiclient.Server
+	activeServer    int
+	onSwitch        func(index int)
+	choosingServer  bool
+}
+
+var _ frontend.Frontend = &Server{}
+
+func New(kiosk Kiosk, screenMgr *screenblankmgr.ScreenBlankManager) *Server {
+	return &Server{
+		Kiosk:           kiosk,
+		ScreenMgr:       screenMgr,
+		nowPlaying:      apiclient.NowPlaying{Status: apiclient.Error},
+		connectionState: apiclient.Disconnected,
+		activeServer:    -1,
+	}
+}
+
+// ListenAndServe serves the API on the given address, e.g. "localhost:8080".
+// It only returns if the server fails.
+func (server *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, server.Handler())
+}
+
+// Handler returns the http.Handler for the API
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", server.getStatus)
+	mux.HandleFunc("POST /page", requireJSON(server.postPage))
+	mux.HandleFunc("POST /screen/wake", requireJSON(server.postScreenWake))
+	mux.HandleFunc("POST /screen/blank", requireJSON(server.postScreenBlank))
+	mux.HandleFunc("POST /theme/reload", requireJSON(server.postThemeReload))
+	mux.HandleFunc("POST /server", requireJSON(server.postServer))
+	return mux
+}
+
+// requireJSON rejects requests that don't say their body is JSON, whether or
+// not they have one. A browser only sends such a request to another origin
+// after a CORS preflight, which is never approved, so a web page can't use
+// the browser of someone on the local network to drive the touchscreen.
+func requireJSON(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Methods implementing frontend.Frontend
+
+func (server *Server) SetApiClient(serverIndex int, apiClient *apiclient.Client) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.activeServer = serverIndex
+	server.searching = false
+	server.choosingServer = false
+	server.nowPlaying = apiclient.NowPlaying{Status: apiclient.Error}
+}
+
+func (server *Server) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.nowPlaying = nowPlaying
+}
+
+func (server *Server) ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.connectionState = state
+	server.retryAt = retryAt
+	if state != apiclient.Connected {
+		server.nowPlaying = apiclient.NowPlaying{Status: apiclient.Error}
+	}
+}
+
+func (server *Server) ShowSearching(searching bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.searching = searching
+}
+
+func (server *Server) ShowServers(servers []apiclient.Server, onSwitch func(index int)) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.servers = servers
+	server.onSwitch = onSwitch
+}
+
+func (server *Server) ChooseServer(servers []apiclient.Server, onChosen func(index int)) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.servers = servers
+	server.onSwitch = onChosen
+	server.choosingServer = true
+	server.searching = false
+}
+
+// The JSON returned by GET /status
+
+type Status struct {
+	NowPlaying     *NowPlaying  `json:"nowPlaying"` // null unless connected
+	Connection     Connection   `json:"connection"`
+	Servers        []ServerInfo `json:"servers"`
+	ActiveServer   *int         `json:"activeServer"` // index into Servers, or null
+	ChoosingServer bool         `json:"choosingServer"`
+	Page           string       `json:"page,omitempty"`
+	ScreenBlanked  *bool        `json:"screenBlanked,omitempty"`
+}
+
+type NowPlaying struct {
+	Status      string   `json:"status"`
+	Track       string   `json:"track,omitempty"`
+	Artist      string   `json:"artist,omitempty"`
+	Album       string   `json:"album,omitempty"`
+	Stream      string   `json:"stream,omitempty"`
+	TrackNumber int      `json:"trackNumber,omitempty"`
+	AlbumTracks int      `json:"albumTracks,omitempty"`
+	ArtworkUri  string   `json:"artworkUri,omitempty"`
+	Scanning    bool     `json:"scanning"`
+	Volume      *int     `json:"volume,omitempty"`
+	Shuffle     string   `json:"shuffle"`
+	Repeat      string   `json:"repeat"`
+	Duration    *float64 `json:"duration,omitempty"` // seconds
+	Elapsed     *float64 `json:"elapsed,omitempty"`  // seconds
+}
+
+type Connection struct {
+	State     string     `json:"state"`
+	RetryAt   *time.Time `json:"retryAt,omitempty"`
+	Searching bool       `json:"searching"`
+}
+
+type ServerInfo struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+}
+
+func (server *Server) status() Status {
+	server.mutex.Lock()
+	status := Status{
+		Connection: Connection{
+			State:     server.connectionState.String(),
+			Searching: server.searching,
+		},
+		Servers:        []ServerInfo{},
+		ChoosingServer: server.choosingServer,
+	}
+	if server.nowPlaying.Status != apiclient.Error {
+		status.NowPlaying = nowPlayingJson(server.nowPlaying)
+	}
+	if server.connectionState == apiclient.Backoff {
+		retryAt := server.retryAt
+		status.Connection.RetryAt = &retryAt
+	}
+	for _, s := range server.servers {
+		status.Servers = append(status.Servers, ServerInfo{Name: s.Name, Host: s.Host})
+	}
+	if server.activeServer >= 0 && !server.choosingServer {
+		activeServer := server.activeServer
+		status.ActiveServer = &activeServer
+	}
+	server.mutex.Unlock()
+
+	// The kiosk may have to wait for the GTK main loop, so mustn't be called
+	// with the mutex held
+	if server.Kiosk != nil {
+		status.Page = server.Kiosk.CurrentPage()
+	}
+	if server.ScreenMgr != nil {
+		blanked := server.ScreenMgr.Blanked()
+		status.ScreenBlanked = &blanked
+	}
+	return status
+}
+
+func nowPlayingJson(nowPlaying apiclient.NowPlaying) *NowPlaying {
+	rtn := &NowPlaying{
+		Status:      nowPlaying.Status.String(),
+		Track:       nowPlaying.TrackName,
+		Artist:      nowPlaying.ArtistName,
+		Album:       nowPlaying.AlbumName,
+		Stream:      nowPlaying.StreamName,
+		TrackNumber: nowPlaying.TrackNumber,
+		AlbumTracks: nowPlaying.AlbumTracks,
+		ArtworkUri:  nowPlaying.ArtworkUri,
+		Scanning:    nowPlaying.Scanning,
+		Shuffle:     nowPlaying.Shuffle.String(),
+		Repeat:      nowPlaying.Repeat.String(),
+	}
+	if nowPlaying.Volume != apiclient.UnknownVolume {
+		volume := nowPlaying.Volume
+		rtn.Volume = &volume
+	}
+	if nowPlaying.Duration > 0 {
+		duration := nowPlaying.Duration.Seconds()
+		rtn.Duration = &duration
+	}
+	if nowPlaying.PositionKnown {
+		elapsed := nowPlaying.ElapsedAt(time.Now()).Seconds()
+		rtn.Elapsed = &elapsed
+	}
+	return rtn
+}
+
+// HTTP handlers
+
+func (server *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server.status())
+}
+
+func (server *Server) postPage(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Page string `json:"page"`
+	}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+	if server.Kiosk == nil {
+		http.Error(w, "Pages are not supported by this user interface", http.StatusNotImplemented)
+		return
+	}
+	if err := server.Kiosk.ShowPage(request.Page); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) postScreenWake(w http.ResponseWriter, r *http.Request) {
+	if server.ScreenMgr == nil {
+		http.Error(w, "Screen blanking is not supported", http.StatusNotImplemented)
+		return
+	}
+	server.ScreenMgr.Wake()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) postScreenBlank(w http.ResponseWriter, r *http.Request) {
+	if server.ScreenMgr == nil {
+		http.Error(w, "Screen blanking is not supported", http.StatusNotImplemented)
+		return
+	}
+	server.ScreenMgr.Blank()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) postThemeReload(w http.ResponseWriter, r *http.Request) {
+	if server.Kiosk == nil {
+		http.Error(w, "Themes are not supported by this user interface", http.StatusNotImplemented)
+		return
+	}
+	if err := server.Kiosk.ReloadTheme(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) postServer(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Index *int   `json:"index"`
+		Name  string `json:"name"`
+	}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+	server.mutex.Lock()
+	servers := server.servers
+	onSwitch := server.onSwitch
+	server.mutex.Unlock()
+
+	if onSwitch == nil {
+		http.Error(w, "There are no servers to switch between", http.StatusConflict)
+		return
+	}
+	index, err := findServer(servers, request.Index, request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	onSwitch(index)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findServer(servers []apiclient.Server, index *int, name string) (int, error) {
+	if index != nil {
+		if *index < 0 || *index >= len(servers) {
+			return 0, fmt.Errorf("No server with index %d", *index)
+		}
+		return *index, nil
+	}
+	if name == "" {
+		return 0, errors.New("Either index or name must be given")
+	}
+	for i, server := range servers {
+		if server.Name == name || server.Host == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("No server named %q", name)
+}
+
+// decodeRequest decodes the JSON request body into request, returning false,
+// having reported the error, if it's invalid
+func decodeRequest(w http.ResponseWriter, r *http.Request, request any) bool {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// ServiceType is the DNS-SD service type advertised by piju servers
+const ServiceType = "_piju._tcp"
+
+// DefaultTimeout is how long Browse waits for servers to answer
+const DefaultTimeout = 3 * time.Second
+
+type Server struct {
+	Name string // The advertised instance name, e.g. "Kitchen"
+	Host string // The server URL, e.g. "http://192.168.1.10:5000/"
+}
+
+// Browse looks for piju servers advertised over mDNS on the local network,
+// returning all those that answer within the timeout, sorted by name
+func Browse(timeout time.Duration) ([]Server, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan *zeroconf.ServiceEntry)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := resolver.Browse(ctx, ServiceType, "local.", entries); err != nil {
+		return nil, err
+	}
+
+	var servers []Server
+	seen := map[string]bool{}
+	// The resolver closes entries when the context expires
+	for entry := range entries {
+		server, ok := serverFromEntry(entry)
+		if ok && !seen[server.Host] {
+			seen[server.Host] = true
+			servers = append(servers, server)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
+
+func serverFromEntry(entry *zeroconf.ServiceEntry) (Server, bool) {
+	// Prefer an address to the host name: .local names are not resolvable
+	// on systems without an mDNS-aware resolver
+	var host string
+	switch {
+	case len(entry.AddrIPv4) > 0:
+		host = entry.AddrIPv4[0].String()
+	case len(entry.AddrIPv6) > 0:
+		host = entry.AddrIPv6[0].String()
+	case entry.HostName != "":
+		host = strings.TrimSuffix(entry.HostName, ".")
+	default:
+		return Server{}, false
+	}
+	name := entry.Instance
+	if name == "" {
+		name = host
+	}
+	// A server that isn't at the root of its web server says where it is
+	// with a TXT record, e.g. "path=/piju"
+	path := "/"
+	for _, txt := range entry.Text {
+		if value, ok := strings.CutPrefix(txt, "path="); ok {
+			if value = strings.Trim(value, "/"); value != "" {
+				path = "/" + value + "/"
+			}
+		}
+	}
+	return Server{
+		Name: name,
+		Host: "http://" + net.JoinHostPort(host, strconv.Itoa(entry.Port)) + path,
+	}, true
+}
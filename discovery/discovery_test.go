@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestServerFromEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    zeroconf.ServiceEntry
+		want     Server
+		wantFail bool
+	}{
+		{
+			name:  "IPv4 preferred",
+			entry: entry("Kitchen", "kitchen.local.", []string{"192.168.1.10"}, []string{"fe80::1"}, nil),
+			want:  Server{Name: "Kitchen", Host: "http://192.168.1.10:5000/"},
+		},
+		{
+			name:  "IPv6 only",
+			entry: entry("Kitchen", "kitchen.local.", nil, []string{"fd00::10"}, nil),
+			want:  Server{Name: "Kitchen", Host: "http://[fd00::10]:5000/"},
+		},
+		{
+			name:  "host name only",
+			entry: entry("Kitchen", "kitchen.local.", nil, nil, nil),
+			want:  Server{Name: "Kitchen", Host: "http://kitchen.local:5000/"},
+		},
+		{
+			name:  "no instance name",
+			entry: entry("", "", []string{"192.168.1.10"}, nil, nil),
+			want:  Server{Name: "192.168.1.10", Host: "http://192.168.1.10:5000/"},
+		},
+		{
+			name:  "path",
+			entry: entry("Kitchen", "", []string{"192.168.1.10"}, nil, []string{"version=7", "path=/piju/"}),
+			want:  Server{Name: "Kitchen", Host: "http://192.168.1.10:5000/piju/"},
+		},
+		{
+			name:  "root path",
+			entry: entry("Kitchen", "", []string{"192.168.1.10"}, nil, []string{"path=/"}),
+			want:  Server{Name: "Kitchen", Host: "http://192.168.1.10:5000/"},
+		},
+		{
+			name:     "no address",
+			entry:    entry("Kitchen", "", nil, nil, nil),
+			wantFail: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := serverFromEntry(&test.entry)
+			if ok == test.wantFail {
+				t.Fatalf("Got ok %v, want %v", ok, !test.wantFail)
+			}
+			if got != test.want {
+				t.Errorf("Got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func entry(instance string, hostName string, ipv4 []string, ipv6 []string, text []string) zeroconf.ServiceEntry {
+	e := zeroconf.NewServiceEntry(instance, ServiceType, "local.")
+	e.HostName = hostName
+	e.Port = 5000
+	e.Text = text
+	for _, addr := range ipv4 {
+		e.AddrIPv4 = append(e.AddrIPv4, net.ParseIP(addr))
+	}
+	for _, addr := range ipv6 {
+		e.AddrIPv6 = append(e.AddrIPv6, net.ParseIP(addr))
+	}
+	return *e
+}
+
+// TestBrowse advertises a server with a local responder, and checks that
+// Browse finds it. It needs a network interface that supports multicast.
+func TestBrowse(t *testing.T) {
+	const instance = "piju-discovery-test"
+	responder, err := zeroconf.Register(instance, ServiceType, "local.", 5123, []string{"path=/piju"}, nil)
+	if err != nil {
+		t.Skip("Unable to advertise a service:", err)
+	}
+	defer responder.Shutdown()
+
+	servers, err := Browse(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		if server.Name != instance {
+			continue
+		}
+		host, err := url.Parse(server.Host)
+		if err != nil || host.Port() != "5123" || host.Path != "/piju/" || host.Scheme != "http" {
+			t.Errorf("Got URL %s, want http://<address>:5123/piju/", server.Host)
+		}
+		return
+	}
+	t.Errorf("Advertised server not found among %+v", servers)
+}
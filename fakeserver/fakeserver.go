@@ -0,0 +1,438 @@
+// Package fakeserver provides an in-process imitation of a piju server, for
+// exercising apiclient without a real one. It serves the status at / and over
+// the websocket at /ws, artwork, canned replies to other GET endpoints, and
+// accepts commands at player/*. Status sequences can be scripted, faults
+// injected into any endpoint, and every command received is recorded.
+package fakeserver
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Status is the status object the server sends. Pointer fields are omitted
+// when nil, as the real server may omit them. Use SetRawStatus for anything
+// that can't be expressed here, such as malformed messages.
+type Status struct {
+	ApiVersion           string   `json:"ApiVersion,omitempty"`
+	PlayerStatus         string   `json:"PlayerStatus"` // "stopped", "playing" or "paused"
+	WorkerStatus         string   `json:"WorkerStatus,omitempty"`
+	CurrentTrack         Track    `json:"CurrentTrack"`
+	CurrentTrackIndex    int      `json:"CurrentTrackIndex,omitempty"`
+	MaximumTrackIndex    int      `json:"MaximumTrackIndex,omitempty"`
+	CurrentArtwork       string   `json:"CurrentArtwork,omitempty"`
+	CurrentStream        string   `json:"CurrentStream,omitempty"`
+	PlayerVolume         *int     `json:"PlayerVolume,omitempty"`
+	CurrentTrackPosition *float64 `json:"CurrentTrackPosition,omitempty"`
+	Shuffle              *bool    `json:"Shuffle,omitempty"`
+	Repeat               *bool    `json:"Repeat,omitempty"`
+}
+
+// Track is the CurrentTrack object within a Status. The zero value is sent
+// as an empty object, meaning there is no current track.
+type Track struct {
+	Artist   string  `json:"artist,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Step is one status in a script. The status is sent After the previous step.
+type Step struct {
+	After  time.Duration
+	Status Status
+}
+
+// Command is a request received at one of the player/* endpoints
+type Command struct {
+	Path string // e.g. "/player/pause"
+	Body []byte // Empty for simple commands
+}
+
+// Decode unmarshals the body of a JSON command into v
+func (command Command) Decode(v any) error {
+	return json.Unmarshal(command.Body, v)
+}
+
+// Fault makes requests to an endpoint misbehave
+type Fault struct {
+	Delay           time.Duration // Wait this long before handling the request
+	StatusCode      int           // Reply with this status code, if non-zero
+	Body            string        // Body to send with StatusCode
+	CloseConnection bool          // Drop the connection without replying
+	Times           int           // How many requests to affect; 0 means every request until cleared
+}
+
+type artwork struct {
+	data []byte
+	etag string
+}
+
+type wsConn struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+}
+
+func (c *wsConn) write(message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// Server is a fake piju server listening on a local port. It is safe for
+// concurrent use.
+type Server struct {
+	// URL is the server's address, in the form expected by apiclient.NewClient
+	URL string
+	// OnCommand, if set, is called for every command received, before it is
+	// answered; it may call SetStatus to imitate the command's effect. It
+	// must be set before any requests are made.
+	OnCommand func(command Command)
+
+	httpServer  *httptest.Server
+	upgrader    websocket.Upgrader
+	mutex       sync.Mutex
+	status      []byte
+	artwork     map[string]artwork // keyed by path, e.g. "/artwork/1"
+	responses   map[string][]byte  // keyed by path, e.g. "/queue/"
+	faults      map[string]*Fault  // keyed by path
+	commands    []Command
+	commandSent chan struct{} // Closed and replaced whenever a command arrives
+	conns       map[*wsConn]bool
+	ignorePings bool
+	stopScript  chan struct{}
+}
+
+// New starts a fake server, initially stopped with no current track
+func New() *Server {
+	server := &Server{
+		artwork:     map[string]artwork{},
+		responses:   map[string][]byte{},
+		faults:      map[string]*Fault{},
+		commandSent: make(chan struct{}),
+		conns:       map[*wsConn]bool{},
+	}
+	server.status, _ = json.Marshal(Status{ApiVersion: "7.0", PlayerStatus: "stopped", WorkerStatus: "Idle"})
+	server.httpServer = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	server.URL = server.httpServer.URL + "/"
+	return server
+}
+
+// Close disconnects any clients and stops the server
+func (server *Server) Close() {
+	server.endScript()
+	server.DisconnectClients()
+	server.httpServer.Close()
+}
+
+// SetStatus changes the status served at /, and sends it to every websocket
+// client
+func (server *Server) SetStatus(status Status) {
+	raw, _ := json.Marshal(status)
+	server.SetRawStatus(string(raw))
+}
+
+// SetRawStatus is like SetStatus, but sends the given text as-is
+func (server *Server) SetRawStatus(raw string) {
+	server.mutex.Lock()
+	server.status = []byte(raw)
+	conns := server.wsConns()
+	server.mutex.Unlock()
+	for _, conn := range conns {
+		conn.write([]byte(raw))
+	}
+}
+
+// PlayScript sends each status in turn, at the given intervals, in the
+// background. Any script already playing is stopped first. The returned
+// channel is closed once the script has finished or been stopped.
+func (server *Server) PlayScript(steps []Step) <-chan struct{} {
+	server.endScript()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	server.mutex.Lock()
+	server.stopScript = stop
+	server.mutex.Unlock()
+	go func() {
+		defer close(done)
+		for _, step := range steps {
+			select {
+			case <-stop:
+				return
+			case <-time.After(step.After):
+			}
+			server.SetStatus(step.Status)
+		}
+	}()
+	return done
+}
+
+// endScript stops the script started by PlayScript, if any
+func (server *Server) endScript() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.stopScript != nil {
+		close(server.stopScript)
+		server.stopScript = nil
+	}
+}
+
+// SetArtwork serves data at the given path, e.g. "/artwork/1". If etag is
+// set, conditional requests that match it are answered with 304 Not Modified.
+func (server *Server) SetArtwork(path string, data []byte, etag string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.artwork[path] = artwork{data: data, etag: etag}
+}
+
+// SetResponse serves value, encoded as JSON, in reply to GET requests for
+// the given path, e.g. "/queue/". The query string is ignored when matching.
+func (server *Server) SetResponse(path string, value any) {
+	raw, _ := json.Marshal(value)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.responses[path] = raw
+}
+
+// InjectFault makes requests for the given path misbehave, replacing any
+// fault already injected there. The websocket is at "/ws".
+func (server *Server) InjectFault(path string, fault Fault) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.faults[path] = &fault
+}
+
+// ClearFaults makes every endpoint behave normally again
+func (server *Server) ClearFaults() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.faults = map[string]*Fault{}
+}
+
+// SetIgnorePings stops the server answering websocket pings, imitating a
+// server that has vanished without closing the connection
+func (server *Server) SetIgnorePings(ignore bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.ignorePings = ignore
+}
+
+// DisconnectClients closes every websocket connection
+func (server *Server) DisconnectClients() {
+	server.mutex.Lock()
+	conns := server.wsConns()
+	server.mutex.Unlock()
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
+
+// Clients returns how many websocket clients are connected
+func (server *Server) Clients() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.conns)
+}
+
+// Commands returns every command received so far, oldest first
+func (server *Server) Commands() []Command {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]Command(nil), server.commands...)
+}
+
+// WaitForCommand waits until a command has been received at the given path,
+// e.g. "/player/pause", returning the first such command
+func (server *Server) WaitForCommand(path string, timeout time.Duration) (Command, bool) {
+	deadline := time.After(timeout)
+	for {
+		server.mutex.Lock()
+		for _, command := range server.commands {
+			if command.Path == path {
+				server.mutex.Unlock()
+				return command, true
+			}
+		}
+		commandSent := server.commandSent
+		server.mutex.Unlock()
+		select {
+		case <-commandSent:
+		case <-deadline:
+			return Command{}, false
+		}
+	}
+}
+
+// wsConns returns a snapshot of the websocket connections. The mutex must be held.
+func (server *Server) wsConns() []*wsConn {
+	conns := make([]*wsConn, 0, len(server.conns))
+	for conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if strings.Contains(path, "//") {
+		// Like the real server, redirect to the path with repeated slashes merged
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+		http.Redirect(w, r, path, http.StatusPermanentRedirect)
+		return
+	}
+	if r.Method == http.MethodPost && strings.HasPrefix(path, "/player/") {
+		server.recordCommand(r)
+	}
+	if server.applyFault(w, path) {
+		return
+	}
+
+	switch {
+	case path == "/ws":
+		server.serveWS(w, r)
+	case path == "/" && r.Method == http.MethodGet:
+		server.mutex.Lock()
+		status := server.status
+		server.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(status)
+	case strings.HasPrefix(path, "/player/") && r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		server.serveGet(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (server *Server) serveGet(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	art, isArtwork := server.artwork[r.URL.Path]
+	response, isResponse := server.responses[r.URL.Path]
+	server.mutex.Unlock()
+	switch {
+	case isArtwork:
+		if art.etag != "" {
+			w.Header().Set("ETag", art.etag)
+			if r.Header.Get("If-None-Match") == art.etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(art.data))
+		w.Write(art.data)
+	case isResponse:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (server *Server) recordCommand(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	command := Command{Path: r.URL.Path, Body: body}
+	server.mutex.Lock()
+	server.commands = append(server.commands, command)
+	close(server.commandSent)
+	server.commandSent = make(chan struct{})
+	server.mutex.Unlock()
+	if server.OnCommand != nil {
+		server.OnCommand(command)
+	}
+}
+
+// applyFault imitates any fault injected for path, returning true if the
+// request has been dealt with
+func (server *Server) applyFault(w http.ResponseWriter, path string) bool {
+	server.mutex.Lock()
+	fault, ok := server.faults[path]
+	var f Fault
+	if ok {
+		f = *fault
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				delete(server.faults, path)
+			}
+		}
+	}
+	server.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	time.Sleep(f.Delay)
+	switch {
+	case f.CloseConnection:
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return true
+			}
+		}
+		panic(http.ErrAbortHandler)
+	case f.StatusCode != 0:
+		w.WriteHeader(f.StatusCode)
+		io.WriteString(w, f.Body)
+		return true
+	}
+	// Just a delay
+	return false
+}
+
+func (server *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := server.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsConn{conn: conn}
+	conn.SetPingHandler(func(data string) error {
+		server.mutex.Lock()
+		ignore := server.ignorePings
+		server.mutex.Unlock()
+		if ignore {
+			return nil
+		}
+		c.writeMutex.Lock()
+		defer c.writeMutex.Unlock()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if _, isNetErr := err.(net.Error); isNetErr {
+			// The client has gone: the read loop will notice
+			return nil
+		}
+		return err
+	})
+
+	server.mutex.Lock()
+	server.conns[c] = true
+	status := server.status
+	server.mutex.Unlock()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.conns, c)
+		server.mutex.Unlock()
+		conn.Close()
+	}()
+
+	// Like the real server, send the current status as soon as a client connects
+	if err := c.write(status); err != nil {
+		return
+	}
+	// Nothing is expected from the client, but reading is needed to process pings
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package frontend defines what a user interface for piju must do, so that
+// the GTK window is just one of several: the connection to the server, and
+// everything that depends on the player state, such as screen blanking, is
+// managed independently of how it is presented.
+package frontend
+
+import (
+	"fmt"
+	"time"
+
+	"nsw42/piju-touchscreen-go/apiclient"
+)
+
+// Frontend presents the state of a piju server to the user. All of its
+// methods may be called from any goroutine: implementations that have to
+// update their display from a particular thread must arrange that themselves.
+type Frontend interface {
+	// SetApiClient is called when a server has been chosen, with its index
+	// in the list passed to ShowServers, or -1 if there is no list, and the
+	// client connected to it. The frontend should send any commands through it.
+	SetApiClient(serverIndex int, apiClient *apiclient.Client)
+	// ShowNowPlaying shows a status received from the server
+	ShowNowPlaying(nowPlaying apiclient.NowPlaying)
+	// ShowConnectionState shows the state of the connection to the server,
+	// and, if it's Backoff, when it will next be retried
+	ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time)
+	// ShowSearching shows whether we are looking for a server to connect to
+	ShowSearching(searching bool)
+	// ShowServers tells the frontend about the servers that are available,
+	// so that it can offer to switch between them by calling onSwitch
+	ShowServers(servers []apiclient.Server, onSwitch func(index int))
+	// ChooseServer asks the user which of several servers to connect to,
+	// calling onChosen with their choice
+	ChooseServer(servers []apiclient.Server, onChosen func(index int))
+}
+
+// Multi returns a Frontend that passes every call on to each of frontends
+// in turn, e.g. to show the same state in a window and over the network
+func Multi(frontends ...Frontend) Frontend {
+	return multi(frontends)
+}
+
+type multi []Frontend
+
+func (frontends multi) SetApiClient(serverIndex int, apiClient *apiclient.Client) {
+	for _, frontend := range frontends {
+		frontend.SetApiClient(serverIndex, apiClient)
+	}
+}
+
+func (frontends multi) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
+	for _, frontend := range frontends {
+		frontend.ShowNowPlaying(nowPlaying)
+	}
+}
+
+func (frontends multi) ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	for _, frontend := range frontends {
+		frontend.ShowConnectionState(state, retryAt)
+	}
+}
+
+func (frontends multi) ShowSearching(searching bool) {
+	for _, frontend := range frontends {
+		frontend.ShowSearching(searching)
+	}
+}
+
+func (frontends multi) ShowServers(servers []apiclient.Server, onSwitch func(index int)) {
+	for _, frontend := range frontends {
+		frontend.ShowServers(servers, onSwitch)
+	}
+}
+
+func (frontends multi) ChooseServer(servers []apiclient.Server, onChosen func(index int)) {
+	for _, frontend := range frontends {
+		frontend.ChooseServer(servers, onChosen)
+	}
+}
+
+// Intent is something the user has asked to do, independent of how they
+// asked, e.g. by tapping a button or pressing a key
+type Intent int
+
+const (
+	PlayPause Intent = iota // Pause if playing, otherwise resume
+	Next
+	Previous
+	VolumeUp
+	VolumeDown
+	Shuffle // Switch shuffle off if it's on, otherwise on
+	Repeat  // Switch repeat off if it's on, otherwise on
+)
+
+func (intent Intent) String() string {
+	switch intent {
+	case PlayPause:
+		return "play/pause"
+	case Next:
+		return "next"
+	case Previous:
+		return "previous"
+	case VolumeUp:
+		return "volume up"
+	case VolumeDown:
+		return "volume down"
+	case Shuffle:
+		return "shuffle"
+	case Repeat:
+		return "repeat"
+	}
+	return "???"
+}
+
+// Perform carries out an intent, given the player status as currently shown,
+// by sending the corresponding command to the server. It returns nil without
+// doing anything if the intent makes no sense in that status.
+func Perform(apiClient *apiclient.Client, intent Intent, nowPlaying apiclient.NowPlaying) error {
+	switch intent {
+	case PlayPause:
+		switch nowPlaying.Status {
+		case apiclient.Playing:
+			return apiClient.SendPause()
+		case apiclient.Paused:
+			return apiClient.SendResume()
+		}
+	case Next:
+		return apiClient.SendNext()
+	case Previous:
+		return apiClient.SendPrevious()
+	case VolumeUp:
+		return apiClient.SendVolumeUp()
+	case VolumeDown:
+		return apiClient.SendVolumeDown()
+	case Shuffle:
+		return apiClient.SetShuffle(nowPlaying.Shuffle != apiclient.ModeOn)
+	case Repeat:
+		return apiClient.SetRepeat(nowPlaying.Repeat != apiclient.ModeOn)
+	}
+	return nil
+}
+
+// FormatDuration formats a track duration or playback position as m:ss, or
+// h:mm:ss for an hour or more
+func FormatDuration(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds/60)%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,76 @@
+// Package logging sets up levelled, structured logging with log/slog, and
+// provides a logger for each subsystem, so that every message says where it
+// came from.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// Options controls where messages are logged, and which
+type Options struct {
+	Debug bool   // Include debug messages
+	File  string // Log to this file, rotating it when it's full, or to Output if ""
+	// MaxSize is the size, in bytes, at which File is rotated, or zero for
+	// DefaultMaxSize
+	MaxSize int64
+	// Backups is how many rotated files to keep, or zero for DefaultBackups
+	Backups int
+	Output  io.Writer // Where to log if File is "". Use io.Discard to log nowhere.
+}
+
+// Setup makes slog, and the standard log package, log as set out in opts. It
+// returns the file being logged to, if any, which should be closed on exit.
+func Setup(opts Options) (io.Closer, error) {
+	output := opts.Output
+	var closer io.Closer
+	if opts.File != "" {
+		file, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.Backups)
+		if err != nil {
+			return nil, err
+		}
+		output = file
+		closer = file
+	}
+	level := slog.LevelInfo
+	if opts.Debug {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: level})))
+	return closer, nil
+}
+
+// For returns the logger for the given subsystem. It logs through whatever
+// handler slog is using at the time, so may be created before calling Setup,
+// e.g. as a package variable.
+func For(subsystem string) *slog.Logger {
+	return slog.New(subsystemHandler{subsystem: slog.String("subsystem", subsystem)})
+}
+
+// subsystemHandler adds the subsystem to each message, before passing it on
+// to the default handler
+type subsystemHandler struct {
+	subsystem slog.Attr
+}
+
+func (handler subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (handler subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(handler.subsystem)
+	return slog.Default().Handler().Handle(ctx, record)
+}
+
+// WithAttrs and WithGroup bind to the default handler at the time they're
+// called, so should only be used after Setup
+
+func (handler subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return slog.Default().Handler().WithAttrs(append([]slog.Attr{handler.subsystem}, attrs...))
+}
+
+func (handler subsystemHandler) WithGroup(name string) slog.Handler {
+	return slog.Default().Handler().WithAttrs([]slog.Attr{handler.subsystem}).WithGroup(name)
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	DefaultMaxSize = 5 * 1024 * 1024
+	DefaultBackups = 3
+)
+
+// RotatingFile is a log file that is renamed, and a new one started, when it
+// reaches a maximum size. Only a limited number of old files are kept, named
+// path.1 (the most recent), path.2, and so on, so the total space used is
+// bounded.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotatingFile opens the log file at path, appending to it if it
+// already exists. maxSize and backups default to DefaultMaxSize and
+// DefaultBackups if zero.
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if backups <= 0 {
+		backups = DefaultBackups
+	}
+	rotatingFile := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rotatingFile.open(); err != nil {
+		return nil, err
+	}
+	return rotatingFile, nil
+}
+
+func (rotatingFile *RotatingFile) open() error {
+	file, err := os.OpenFile(rotatingFile.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotatingFile.file = file
+	rotatingFile.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, first rotating it if p would take it over the
+// maximum size
+func (rotatingFile *RotatingFile) Write(p []byte) (int, error) {
+	rotatingFile.mutex.Lock()
+	defer rotatingFile.mutex.Unlock()
+	if rotatingFile.size > 0 && rotatingFile.size+int64(len(p)) > rotatingFile.maxSize {
+		if err := rotatingFile.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rotatingFile.file.Write(p)
+	rotatingFile.size += int64(n)
+	return n, err
+}
+
+// rotate renames each file to the next number up, discarding the oldest,
+// and starts a new file. The mutex must be held.
+func (rotatingFile *RotatingFile) rotate() error {
+	rotatingFile.file.Close()
+	for n := rotatingFile.backups - 1; n > 0; n-- {
+		// Failure is expected if there aren't yet that many old files
+		os.Rename(rotatingFile.backupPath(n), rotatingFile.backupPath(n+1))
+	}
+	// If this fails, carry on appending to the current file, rather than
+	// losing messages
+	os.Rename(rotatingFile.path, rotatingFile.backupPath(1))
+	return rotatingFile.open()
+}
+
+func (rotatingFile *RotatingFile) backupPath(n int) string {
+	return rotatingFile.path + "." + strconv.Itoa(n)
+}
+
+func (rotatingFile *RotatingFile) Close() error {
+	rotatingFile.mutex.Lock()
+	defer rotatingFile.mutex.Unlock()
+	return rotatingFile.file.Close()
+}
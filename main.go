@@ -1,57 +1,111 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"net/http"
 	_ "net/http/pprof"
 
 	"github.com/akamensky/argparse"
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
-	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 
 	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/artworkcache"
+	"nsw42/piju-touchscreen-go/controlapi"
+	"nsw42/piju-touchscreen-go/discovery"
+	"nsw42/piju-touchscreen-go/frontend"
+	"nsw42/piju-touchscreen-go/logging"
 	"nsw42/piju-touchscreen-go/mainwindow"
+	"nsw42/piju-touchscreen-go/metrics"
 	"nsw42/piju-touchscreen-go/screenblankmgr"
+	"nsw42/piju-touchscreen-go/tui"
+	"nsw42/piju-touchscreen-go/watchdog"
 )
 
 type Arguments struct {
-	Debug bool
-	Host  string
-	PProf bool
+	CommandLine []string // As given, including the program name, for restarting
+	Debug       bool
+	Hosts       []string
+	PProf       bool
+	TUI         bool
+	// Options related to logging
+	LogFile    string
+	LogMaxSize int64
+	LogBackups int
+	// Addresses on which to serve the control API and metrics, or "" for none
+	ControlAddr string
+	MetricsAddr string
+	// Options related to the server connection
+	Failover     bool
+	StrictStatus bool
+	StaleTimeout time.Duration
+	// Options related to recording and replaying sessions
+	RecordDir   string
+	ReplayFile  string
+	ReplaySpeed float64
+	// Options related to restarting to free memory
+	MaxMemory   int64
+	RestartHour int
+	// Options related to the artwork cache
+	ArtworkCacheDir  string
+	ArtworkCacheSize int64
 	// Options related to the main window
 	DarkMode           bool
 	FullScreen         bool
 	FixedLayout        bool
 	CloseButton        bool
 	HideMousePointer   bool
+	ThemeFile          string
 	ScreenBlankProfile screenblankmgr.ProfileBase
 }
 
+var logger = logging.For("main")
+
 var args Arguments
 var mainWindow *mainwindow.MainWindow
-var apiClient *apiclient.Client
+var servers *apiclient.ServerSet
 var screenMgr *screenblankmgr.ScreenBlankManager
+var recorder *apiclient.Recorder
+
+// The window can be driven by the control API
+var _ controlapi.Kiosk = mainwindow.Frontend{}
 
 func parseArgs() bool {
-	var defaultHost string
-	if hostname, err := os.Hostname(); err == nil {
-		defaultHost = hostname + ":5000"
-	}
 	parser := argparse.NewParser("piju-touchscreen", "A GTK-based touchscreen UI for piju")
 	debugArg := parser.Flag("", "debug", &argparse.Options{Default: false, Help: "Enable debug output"})
-	hostArg := parser.String("", "host", &argparse.Options{Default: defaultHost, Help: "Connect to server at the given address"})
+	logFileArg := parser.String("", "log-file", &argparse.Options{Help: "Log to the given file, rather than stderr, starting a new file when it is full"})
+	logMaxSizeArg := parser.Int("", "log-max-size", &argparse.Options{Default: logging.DefaultMaxSize / 1024 / 1024, Help: "Size, in MB, at which to start a new log file"})
+	logBackupsArg := parser.Int("", "log-backups", &argparse.Options{Default: logging.DefaultBackups, Help: "Number of full log files to keep"})
+	hostArg := parser.StringList("", "host", &argparse.Options{Help: "Connect to server at the given address. May be given more than once, to allow switching between servers. If not given, look for servers on the local network"})
+	failoverArg := parser.Flag("", "failover", &argparse.Options{Default: false, Help: "Switch to the next server if the current one is unreachable"})
+	uiArg := parser.Selector("", "ui", []string{"gtk", "tui"}, &argparse.Options{Default: "gtk", Help: "Select the user interface: gtk for the touchscreen, or tui to run in a terminal"})
+	controlArg := parser.String("", "control-api", &argparse.Options{Help: "Serve an HTTP API to report the state of the UI and control it on the given address, e.g. localhost:8080"})
+	metricsArg := parser.String("", "metrics", &argparse.Options{Help: "Serve metrics for Prometheus at /metrics on the given address, e.g. :9100"})
 	pprofArg := parser.Flag("", "pprof", &argparse.Options{Default: false, Help: "Enable profiling server on port 6060"})
+	staleArg := parser.Int("", "stale-timeout", &argparse.Options{Default: int(apiclient.DefaultStaleTimeout / time.Second), Help: "Treat the server connection as lost if nothing is heard from the server for this many seconds"})
+	cacheDirArg := parser.String("", "artwork-cache-dir", &argparse.Options{Default: artworkcache.DefaultDir(), Help: "Directory in which to cache artwork"})
+	cacheSizeArg := parser.Int("", "artwork-cache-size", &argparse.Options{Default: 50, Help: "Maximum size of the artwork cache, in MB. 0 disables the cache"})
+	recordArg := parser.String("", "record", &argparse.Options{Help: "Record every status message and artwork received from the server to a new file in the given directory"})
+	replayArg := parser.String("", "replay", &argparse.Options{Help: "Replay a recorded session file instead of connecting to a server"})
+	replaySpeedArg := parser.Float("", "replay-speed", &argparse.Options{Default: 1.0, Help: "How many times faster than the original pace to replay a session"})
+	maxMemoryArg := parser.Int("", "max-memory", &argparse.Options{Default: 0, Help: "Restart if memory use exceeds this many MB. 0 means no limit"})
+	restartHourArg := parser.Int("", "restart-hour", &argparse.Options{Default: watchdog.NoQuietHour, Help: "Restart during this hour of the day (0-23) if nothing is playing and the screen is blanked, to free memory. -1 means never"})
+	strictArg := parser.Flag("", "strict-status", &argparse.Options{Default: false, Help: "Reject status messages from the server that omit any expected field, or are from an unsupported API version"})
 	modeArg := parser.Selector("m", "mode", []string{"dark", "light"}, &argparse.Options{Default: "light", Help: "Select the colour scheme of the UI: dark or light"})
 	fullscreenArg := parser.Flag("", "fullscreen", &argparse.Options{Default: false, Help: "Show the main window full-screen"})
 	layoutArg := parser.Selector("l", "layout", []string{"dynamic", "fixed"}, &argparse.Options{Default: "dynamic", Help: "Select whether to use a fixed layout or a dynamic layout to position controls"})
 	closeButtonArg := parser.Flag("", "closebutton", &argparse.Options{Default: false, Help: "Show a close button"})
 	hideMouseArg := parser.Flag("", "hidemousepointer", &argparse.Options{Default: false, Help: "Hide the mouse pointer when it is in the window"})
+	cssArg := parser.String("", "css", &argparse.Options{Help: "Load an extra stylesheet to customise the look of the UI"})
 	screenblankArg := parser.Selector("", "screenblanker-profile", []string{"none", "balanced", "onoff"}, &argparse.Options{Default: "none", Help: "Actively manage the screen blank based on playpack state"})
 
+	args.CommandLine = os.Args
 	if err := parser.Parse(os.Args); err != nil {
 		fmt.Println(err)
 		fmt.Print(parser.Usage(err))
@@ -59,13 +113,37 @@ func parseArgs() bool {
 	}
 
 	args.Debug = *debugArg
-	args.Host = *hostArg
+	args.LogFile = *logFileArg
+	args.LogMaxSize = int64(*logMaxSizeArg) * 1024 * 1024
+	args.LogBackups = *logBackupsArg
+	args.Failover = *failoverArg
 	args.PProf = *pprofArg
+	args.TUI = (*uiArg == "tui")
+	args.ControlAddr = *controlArg
+	args.MetricsAddr = *metricsArg
+	args.StrictStatus = *strictArg
+	args.StaleTimeout = time.Duration(*staleArg) * time.Second
+	args.RecordDir = *recordArg
+	args.ReplayFile = *replayArg
+	args.ReplaySpeed = *replaySpeedArg
+	if args.ReplaySpeed < 1 {
+		fmt.Println("--replay-speed must be at least 1")
+		return false
+	}
+	args.MaxMemory = int64(*maxMemoryArg) * 1024 * 1024
+	args.RestartHour = *restartHourArg
+	if args.RestartHour < watchdog.NoQuietHour || args.RestartHour > 23 {
+		fmt.Println("--restart-hour must be between 0 and 23, or -1")
+		return false
+	}
+	args.ArtworkCacheDir = *cacheDirArg
+	args.ArtworkCacheSize = int64(*cacheSizeArg) * 1024 * 1024
 	args.DarkMode = (*modeArg == "dark")
 	args.FullScreen = *fullscreenArg
 	args.FixedLayout = (*layoutArg == "fixed")
 	args.CloseButton = *closeButtonArg
 	args.HideMousePointer = *hideMouseArg
+	args.ThemeFile = *cssArg
 	switch *screenblankArg {
 	case "none":
 		args.ScreenBlankProfile = &screenblankmgr.ProfileNone{}
@@ -75,14 +153,17 @@ func parseArgs() bool {
 		args.ScreenBlankProfile = &screenblankmgr.ProfileOnOff{}
 	}
 
-	if !strings.HasPrefix(args.Host, "http") {
-		args.Host = "http://" + args.Host
-	}
-	if !strings.Contains(args.Host[6:], ":") {
-		args.Host += ":5000"
-	}
-	if !strings.HasSuffix(args.Host, "/") {
-		args.Host += "/"
+	for _, host := range *hostArg {
+		if !strings.HasPrefix(host, "http") {
+			host = "http://" + host
+		}
+		if !strings.Contains(host[6:], ":") {
+			host += ":5000"
+		}
+		if !strings.HasSuffix(host, "/") {
+			host += "/"
+		}
+		args.Hosts = append(args.Hosts, host)
 	}
 
 	// Prevent GTK from parsing the arguments
@@ -96,42 +177,227 @@ func main() {
 		return
 	}
 
+	logOptions := logging.Options{
+		Debug:   args.Debug,
+		File:    args.LogFile,
+		MaxSize: args.LogMaxSize,
+		Backups: args.LogBackups,
+		Output:  os.Stderr,
+	}
+	if args.TUI {
+		// Log messages would corrupt the display, so only log to a file
+		logOptions.Output = io.Discard
+	}
+	logFile, err := logging.Setup(logOptions)
+	if err != nil {
+		fmt.Println("Unable to open log file:", err)
+		os.Exit(1)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	watchdog.LogRestart()
+
 	if args.PProf {
 		go func() {
 			http.ListenAndServe(":6060", nil)
 		}()
 	}
 
-	apiClient = &apiclient.Client{IsConnected: false, Host: args.Host}
+	var cache *artworkcache.Cache
+	if args.ArtworkCacheSize > 0 && args.ArtworkCacheDir != "" {
+		var err error
+		cache, err = artworkcache.New(args.ArtworkCacheDir, args.ArtworkCacheSize)
+		if err != nil {
+			logger.Error("Unable to open artwork cache", "err", err)
+		}
+	}
+	var stats *metrics.Metrics
+	if args.MetricsAddr != "" {
+		stats = metrics.New()
+		go func() {
+			if err := stats.ListenAndServe(args.MetricsAddr); err != nil {
+				logger.Error("Unable to serve metrics", "err", err)
+			}
+		}()
+	}
+	if args.RecordDir != "" {
+		var err error
+		recorder, err = apiclient.NewRecorder(args.RecordDir)
+		if err != nil {
+			logger.Error("Unable to record session", "err", err)
+		}
+	}
+	servers = &apiclient.ServerSet{Failover: args.Failover}
+	for _, host := range args.Hosts {
+		servers.Servers = append(servers.Servers, apiclient.Server{Name: host, Host: host})
+	}
+	servers.Configure = func(client *apiclient.Client) {
+		client.StaleTimeout = args.StaleTimeout
+		client.ArtworkCache = cache
+		client.Recorder = recorder
+		client.Metrics = stats
+		if args.StrictStatus {
+			client.DecodeMode = apiclient.Strict
+		}
+	}
 	screenMgr = screenblankmgr.NewScreenBlankManager(args.ScreenBlankProfile)
+	screenMgr.Metrics = stats
+
+	if args.TUI {
+		runTUI()
+		closeRecorder()
+		return
+	}
 
 	app := gtk.NewApplication("com.github.nsw42.piju-touchscreen-go", gio.ApplicationFlagsNone)
 	app.ConnectActivate(func() { activate(app) })
 
-	if code := app.Run(os.Args); code > 0 {
+	code := app.Run(os.Args)
+	closeRecorder()
+	if code > 0 {
 		os.Exit(code)
 	}
 }
 
+// closeRecorder finishes the session file being recorded, if any
+func closeRecorder() {
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			logger.Error("Error closing session file", "err", err)
+		}
+	}
+}
+
 func activate(app *gtk.Application) {
 	mainWindow = mainwindow.NewMainWindow(app,
-		apiClient,
+		apiclient.NewClient(""), // Replaced once a server has been chosen
 		args.DarkMode,
 		args.FullScreen,
 		args.FixedLayout,
 		args.CloseButton,
-		args.HideMousePointer)
+		args.HideMousePointer,
+		args.ThemeFile)
+
+	ui := mainWindow.Frontend()
+	start(ui, ui)
+}
+
+// runTUI runs the terminal UI until the user quits
+func runTUI() {
+	ui := tui.New(os.Stdin, os.Stdout)
+	start(ui, nil)
+	if err := ui.Run(); err != nil {
+		fmt.Println("Unable to run terminal UI:", err)
+		os.Exit(1)
+	}
+}
 
-	glib.TimeoutAdd(5000, func() bool {
-		if !apiClient.IsConnected {
-			mainWindow.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Error})
-			apiClient.ConnectWS(mainWindow.QueueShowNowPlaying)
+// start connects the frontend to a server, finding one first if necessary,
+// or to the session being replayed. kiosk may be nil if the frontend can't
+// be driven by the control API.
+func start(ui frontend.Frontend, kiosk controlapi.Kiosk) {
+	if args.ControlAddr != "" {
+		api := controlapi.New(kiosk, screenMgr)
+		go func() {
+			if err := api.ListenAndServe(args.ControlAddr); err != nil {
+				logger.Error("Unable to serve control API", "err", err)
+			}
+		}()
+		ui = frontend.Multi(ui, api)
+	}
+	servers.OnStateChange = ui.ShowConnectionState
+	servers.ShowNowPlaying = ui.ShowNowPlaying
+	servers.OnSwitch = ui.SetApiClient
+	playerStatus := servers.PlayerStatus
+	if args.ReplayFile != "" {
+		client := startReplay(ui)
+		playerStatus = client.PlayerStatus
+	} else if len(servers.Servers) == 0 {
+		ui.ShowSearching(true)
+		go func() {
+			discoverServers()
+			ui.ShowServers(servers.Servers, servers.Switch)
+			if len(servers.Servers) == 1 {
+				servers.Switch(0)
+			} else {
+				ui.ChooseServer(servers.Servers, servers.Switch)
+			}
+		}()
+	} else {
+		ui.ShowServers(servers.Servers, servers.Switch)
+		servers.Switch(0)
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			screenMgr.SetState(playerStatus())
 		}
-		return glib.SOURCE_CONTINUE // =please keep calling me
-	})
-	glib.TimeoutAdd(1000, func() bool {
-		mainWindow.CheckWindowSize()
-		screenMgr.SetState(apiClient.PlayerStatus)
-		return glib.SOURCE_CONTINUE // =please keep calling me
-	})
+	}()
+
+	if args.MaxMemory > 0 || args.RestartHour != watchdog.NoQuietHour {
+		if args.TUI {
+			// The terminal would be left in raw mode
+			logger.Warn("Restarting to free memory is not supported by the terminal UI")
+		} else {
+			startWatchdog(playerStatus)
+		}
+	}
+}
+
+// startWatchdog restarts the UI, to work around the gotk4 memory leak, when
+// it uses too much memory, or at the quiet hour if it's not in use
+func startWatchdog(playerStatus func() apiclient.Status) {
+	_, unknownBlanking := args.ScreenBlankProfile.(*screenblankmgr.ProfileNone)
+	memoryWatchdog := &watchdog.Watchdog{
+		MaxRSS:    args.MaxMemory,
+		QuietHour: args.RestartHour,
+		Idle: func() bool {
+			if playerStatus() == apiclient.Playing {
+				return false
+			}
+			// Without a screen blanker profile, there's no telling whether
+			// the screen is blank
+			return unknownBlanking || screenMgr.Blanked()
+		},
+		Args:          args.CommandLine,
+		BeforeRestart: closeRecorder,
+	}
+	go memoryWatchdog.Run()
+}
+
+// startReplay shows a recorded session instead of connecting to a server
+func startReplay(ui frontend.Frontend) *apiclient.Client {
+	client := apiclient.NewClient("")
+	servers.Configure(client)
+	client.Recorder = nil
+	client.OnStateChange = ui.ShowConnectionState
+	ui.SetApiClient(-1, client)
+	go func() {
+		if err := client.Replay(context.Background(), args.ReplayFile, args.ReplaySpeed, ui.ShowNowPlaying); err != nil {
+			logger.Error("Error replaying session", "err", err)
+		}
+	}()
+	return client
+}
+
+// discoverServers looks for piju servers on the local network until it finds
+// at least one, adding all those found to servers
+func discoverServers() {
+	for {
+		found, err := discovery.Browse(discovery.DefaultTimeout)
+		if err != nil {
+			logger.Error("Error looking for servers", "err", err)
+			time.Sleep(discovery.DefaultTimeout)
+		}
+		if len(found) == 0 {
+			logger.Info("No servers found - retrying")
+			continue
+		}
+		for _, server := range found {
+			logger.Info("Found server", "name", server.Name, "host", server.Host)
+			servers.Servers = append(servers.Servers, apiclient.Server{Name: server.Name, Host: server.Host})
+		}
+		return
+	}
 }
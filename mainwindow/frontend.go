@@ -0,0 +1,80 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+)
+
+// Frontend presents the main window as a frontend.Frontend, and to the
+// control API, passing every call on to the GTK main loop
+type Frontend struct {
+	window *MainWindow
+}
+
+var _ frontend.Frontend = Frontend{}
+
+func (window *MainWindow) Frontend() Frontend {
+	return Frontend{window: window}
+}
+
+func (ui Frontend) SetApiClient(serverIndex int, apiClient *apiclient.Client) {
+	glib.IdleAdd(func() bool {
+		ui.window.SetApiClient(apiClient)
+		ui.window.ShowActiveServer(serverIndex)
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (ui Frontend) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
+	ui.window.QueueShowNowPlaying(nowPlaying)
+}
+
+func (ui Frontend) ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	ui.window.QueueShowConnectionState(state, retryAt)
+}
+
+func (ui Frontend) ShowSearching(searching bool) {
+	glib.IdleAdd(func() bool {
+		ui.window.ShowSearching(searching)
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (ui Frontend) ShowServers(servers []apiclient.Server, onSwitch func(index int)) {
+	glib.IdleAdd(func() bool {
+		ui.window.SetServers(servers, onSwitch)
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (ui Frontend) ChooseServer(servers []apiclient.Server, onChosen func(index int)) {
+	glib.IdleAdd(func() bool {
+		ui.window.ShowServerPicker(servers, onChosen)
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (ui Frontend) CurrentPage() string {
+	return onMainLoop(func() string { return ui.window.State.String() })
+}
+
+func (ui Frontend) ShowPage(page string) error {
+	return onMainLoop(func() error { return ui.window.ShowPage(page) })
+}
+
+func (ui Frontend) ReloadTheme() error {
+	return onMainLoop(ui.window.ReloadTheme)
+}
+
+// onMainLoop calls f on the GTK main loop, and waits for its result
+func onMainLoop[T any](f func() T) T {
+	result := make(chan T, 1)
+	glib.IdleAdd(func() bool {
+		result <- f()
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+	return <-result
+}
@@ -0,0 +1,146 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+	"strconv"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+// libraryPages are the pages used to browse the library: artists, then the
+// albums by one of them, then the tracks on one of those
+type libraryPages struct {
+	Artists         *listPage
+	Albums          *listPage
+	Tracks          *listPage
+	PlayAlbumButton *gtk.Button
+	Album           apiclient.Album // The album whose tracks are shown
+}
+
+func (window *MainWindow) newLibraryPages() *libraryPages {
+	library := &libraryPages{
+		Artists: window.newListPage("Artists"),
+		Albums:  window.newGridPage("Albums"),
+		Tracks:  window.newListPage("Tracks"),
+	}
+	library.Albums.OnBack = func() { window.setState(MainWindowStateArtists) }
+	library.Tracks.OnBack = func() { window.setState(MainWindowStateAlbums) }
+
+	library.PlayAlbumButton = gtk.NewButtonWithLabel("Play album")
+	library.PlayAlbumButton.SetFocusOnClick(false)
+	library.PlayAlbumButton.SetVisible(false)
+	library.PlayAlbumButton.ConnectClicked(func() {
+		album := library.Album
+		apiClient := window.ApiClient
+		window.runCommand(func() error { return apiClient.SendPlayAlbum(album) })
+		window.ShowControls()
+	})
+	if window.DarkMode {
+		library.PlayAlbumButton.AddCSSClass("piju-dark-button")
+	}
+	library.Tracks.Header.Append(library.PlayAlbumButton)
+	return library
+}
+
+// ShowLibrary starts browsing the library, from the list of artists
+func (window *MainWindow) ShowLibrary() {
+	page := window.Library.Artists
+	window.setState(MainWindowStateArtists)
+	page.ShowMessage("Loading…")
+	fetchInBackground(window, (*apiclient.Client).GetArtists, func(artists []apiclient.Artist, err error) {
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		if len(artists) == 0 {
+			page.ShowMessage("The library is empty")
+			return
+		}
+		page.Clear()
+		for _, artist := range artists {
+			page.AddRow("", artist.Name, "", func() { window.showArtist(artist) })
+		}
+	})
+}
+
+func (window *MainWindow) showArtist(artist apiclient.Artist) {
+	page := window.Library.Albums
+	page.Title.SetLabel(artist.Name)
+	window.setState(MainWindowStateAlbums)
+	page.ShowMessage("Loading…")
+	page.generation++
+	generation := page.generation
+	fetch := func(apiClient *apiclient.Client) ([]apiclient.Album, error) {
+		return apiClient.GetArtistAlbums(artist)
+	}
+	fetchInBackground(window, fetch, func(albums []apiclient.Album, err error) {
+		if generation != page.generation {
+			// Another artist has been chosen since
+			return
+		}
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		if len(albums) == 0 {
+			page.ShowMessage("No albums")
+			return
+		}
+		page.Clear()
+		for _, album := range albums {
+			page.AddTile(album.Artwork, album.Title, func() { window.showAlbum(album) })
+		}
+	})
+}
+
+func (window *MainWindow) showAlbum(album apiclient.Album) {
+	library := window.Library
+	page := library.Tracks
+	page.Title.SetLabel(album.Title)
+	library.PlayAlbumButton.SetVisible(false)
+	window.setState(MainWindowStateTracks)
+	page.ShowMessage("Loading…")
+	page.generation++
+	generation := page.generation
+	fetch := func(apiClient *apiclient.Client) (apiclient.Album, error) {
+		return apiClient.GetAlbum(album.Link)
+	}
+	fetchInBackground(window, fetch, func(album apiclient.Album, err error) {
+		if generation != page.generation {
+			// Another album has been chosen since, so this one mustn't be
+			// the one that "Play album" plays
+			return
+		}
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		library.Album = album
+		if len(album.Tracks) == 0 {
+			page.ShowMessage("No tracks")
+			return
+		}
+		library.PlayAlbumButton.SetVisible(true)
+		page.Clear()
+		for _, track := range album.Tracks {
+			title := track.Title
+			if track.TrackNumber > 0 {
+				title = strconv.Itoa(track.TrackNumber) + ". " + title
+			}
+			var details string
+			if track.Duration > 0 {
+				details = frontend.FormatDuration(time.Duration(track.Duration * float64(time.Second)))
+			}
+			if track.Artist != "" && track.Artist != album.Artist {
+				details = track.Artist + "  " + details
+			}
+			page.AddRow("", title, details, func() {
+				apiClient := window.ApiClient
+				window.runCommand(func() error { return apiClient.SendPlayAlbumFromTrack(album, track) })
+				window.ShowControls()
+			})
+		}
+	})
+}
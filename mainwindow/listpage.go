@@ -0,0 +1,333 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+
+	"github.com/diamondburned/gotk4/pkg/gdkpixbuf/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+const (
+	thumbnailSize = 64
+	tileSize      = 160
+
+	// Leave room at the start of the page header for the menu button
+	pageHeaderIndent = 64
+
+	// How many thumbnails to fetch at once
+	maxThumbnailFetches = 4
+)
+
+// thumbnailFetches limits how many thumbnails are fetched concurrently, so
+// that filling a long list doesn't swamp the server
+var thumbnailFetches = make(chan struct{}, maxThumbnailFetches)
+
+// listPage is a full-screen page with a title, a back button and a
+// scrollable list of rows, or grid of tiles, each of which does something
+// when tapped
+type listPage struct {
+	Container *gtk.Box
+	Header    *gtk.Box
+	Title     *gtk.Label
+	Status    *gtk.Label // Shown instead of the list while loading, or if it's empty
+	Scroller  *gtk.ScrolledWindow
+	List      *gtk.ListBox
+	Grid      *gtk.FlowBox // Replaces List, for pages created by newGridPage
+	// ShowThumbnails leaves room for artwork at the start of each row
+	ShowThumbnails bool
+	OnBack         func()
+	window         *MainWindow
+	actions        []func()
+	// generation is incremented each time the page starts loading, or
+	// searching, so that results for what it showed before can be ignored
+	generation int
+}
+
+func (window *MainWindow) newListPage(title string) *listPage {
+	page := &listPage{OnBack: window.ShowControls, window: window}
+
+	backButton := gtk.NewButtonFromIconName("go-previous-symbolic")
+	backButton.SetFocusOnClick(false)
+	backButton.SetSizeRequest(thumbnailSize, thumbnailSize*3/4)
+	backButton.ConnectClicked(func() { page.OnBack() })
+
+	page.Title = mkLabel(gtk.JustifyLeft, true, window.DarkMode)
+	page.Title.SetLabel(title)
+	page.Title.SetVExpand(false)
+	page.Title.SetWrap(false)
+
+	page.Header = gtk.NewBox(gtk.OrientationHorizontal, 12)
+	page.Header.SetMarginStart(pageHeaderIndent)
+	page.Header.SetMarginEnd(12)
+	page.Header.SetMarginTop(8)
+	page.Header.Append(backButton)
+	page.Header.Append(page.Title)
+
+	page.Status = mkLabel(gtk.JustifyCenter, false, window.DarkMode)
+	page.Status.SetVisible(false)
+
+	page.List = gtk.NewListBox()
+	page.List.SetSelectionMode(gtk.SelectionNone)
+	page.List.SetActivateOnSingleClick(true)
+	page.List.ConnectRowActivated(func(row *gtk.ListBoxRow) {
+		if index := row.Index(); index >= 0 && index < len(page.actions) && page.actions[index] != nil {
+			page.actions[index]()
+		}
+	})
+
+	page.Scroller = gtk.NewScrolledWindow()
+	page.Scroller.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	page.Scroller.SetVExpand(true)
+	page.Scroller.SetChild(page.List)
+
+	page.Container = gtk.NewBox(gtk.OrientationVertical, 8)
+	page.Container.Append(page.Header)
+	page.Container.Append(page.Status)
+	page.Container.Append(page.Scroller)
+	page.Container.SetVisible(false)
+	if window.DarkMode {
+		page.Header.AddCSSClass("piju-dark-background")
+		page.List.AddCSSClass("piju-dark-background")
+		backButton.AddCSSClass("piju-dark-button")
+	}
+	return page
+}
+
+func (window *MainWindow) newGridPage(title string) *listPage {
+	page := window.newListPage(title)
+	page.Grid = gtk.NewFlowBox()
+	page.Grid.SetSelectionMode(gtk.SelectionNone)
+	page.Grid.SetActivateOnSingleClick(true)
+	page.Grid.SetHomogeneous(true)
+	page.Grid.SetMaxChildrenPerLine(8)
+	page.Grid.SetVAlign(gtk.AlignStart)
+	page.Grid.ConnectChildActivated(func(child *gtk.FlowBoxChild) {
+		if index := child.Index(); index >= 0 && index < len(page.actions) {
+			page.actions[index]()
+		}
+	})
+	page.Scroller.SetChild(page.Grid)
+	return page
+}
+
+// ShowMessage replaces the contents of the list with a message, e.g.
+// "Loading…"
+func (page *listPage) ShowMessage(message string) {
+	page.Clear()
+	page.Status.SetLabel(message)
+	page.Status.SetVisible(true)
+	page.Scroller.SetVisible(false)
+}
+
+// Clear removes all rows from the list
+func (page *listPage) Clear() {
+	if page.Grid != nil {
+		page.Grid.RemoveAll()
+	} else {
+		page.List.RemoveAll()
+	}
+	page.actions = nil
+	page.Scroller.VAdjustment().SetValue(0)
+}
+
+// AddRow appends a row showing a thumbnail, if the page has them and
+// artworkUri is set, and one or two lines of text. action is called when the row is tapped.
+func (page *listPage) AddRow(artworkUri string, primary string, secondary string, action func()) {
+	window := page.window
+	page.Status.SetVisible(false)
+	page.Scroller.SetVisible(true)
+
+	row := gtk.NewBox(gtk.OrientationHorizontal, 12)
+	row.SetMarginStart(12)
+	row.SetMarginEnd(12)
+	row.SetMarginTop(4)
+	row.SetMarginBottom(4)
+
+	if page.ShowThumbnails {
+		thumbnail := gtk.NewImage()
+		thumbnail.SetSizeRequest(thumbnailSize, thumbnailSize)
+		row.Append(thumbnail)
+		if artworkUri != "" {
+			window.loadThumbnail(thumbnail, artworkUri, thumbnailSize)
+		}
+	}
+
+	labels := gtk.NewBox(gtk.OrientationVertical, 0)
+	labels.SetVAlign(gtk.AlignCenter)
+	primaryLabel := mkLabel(gtk.JustifyLeft, false, window.DarkMode)
+	primaryLabel.SetLabel(primary)
+	primaryLabel.SetWrap(false)
+	labels.Append(primaryLabel)
+	if secondary != "" {
+		secondaryLabel := mkSmallLabel(window.DarkMode)
+		secondaryLabel.SetLabel(secondary)
+		secondaryLabel.SetXAlign(0)
+		labels.Append(secondaryLabel)
+	}
+	row.Append(labels)
+
+	page.List.Append(row)
+	page.actions = append(page.actions, action)
+}
+
+// AddTile appends a tile showing artwork, if artworkUri is set, with a
+// caption underneath. action is called when the tile is tapped.
+func (page *listPage) AddTile(artworkUri string, caption string, action func()) {
+	window := page.window
+	page.Status.SetVisible(false)
+	page.Scroller.SetVisible(true)
+
+	tile := gtk.NewBox(gtk.OrientationVertical, 4)
+	tile.SetMarginStart(8)
+	tile.SetMarginEnd(8)
+	tile.SetMarginTop(8)
+	tile.SetMarginBottom(8)
+
+	artwork := gtk.NewImage()
+	artwork.SetSizeRequest(tileSize, tileSize)
+	tile.Append(artwork)
+	if artworkUri != "" {
+		window.loadThumbnail(artwork, artworkUri, tileSize)
+	}
+
+	label := mkSmallLabel(window.DarkMode)
+	label.SetLabel(caption)
+	label.SetWrap(true)
+	label.SetMaxWidthChars(16)
+	label.SetJustify(gtk.JustifyCenter)
+	tile.Append(label)
+
+	page.Grid.Append(tile)
+	page.actions = append(page.actions, action)
+}
+
+// AddHeading appends a row that introduces the rows that follow it, and
+// does nothing when tapped
+func (page *listPage) AddHeading(text string) {
+	page.Status.SetVisible(false)
+	page.Scroller.SetVisible(true)
+
+	label := mkSmallLabel(page.window.DarkMode)
+	label.SetLabel(text)
+	label.SetXAlign(0)
+	label.SetMarginStart(12)
+	label.SetMarginTop(8)
+
+	row := gtk.NewListBoxRow()
+	row.SetActivatable(false)
+	row.SetChild(label)
+	page.List.Append(row)
+	page.actions = append(page.actions, nil)
+}
+
+// loadThumbnail fetches artwork in the background, and shows it in image,
+// scaled to fit within size, once it arrives
+func (window *MainWindow) loadThumbnail(image *gtk.Image, artworkUri string, size int) {
+	apiClient := window.ApiClient
+	go func() {
+		thumbnailFetches <- struct{}{}
+		data := apiClient.FetchArtwork(artworkUri)
+		<-thumbnailFetches
+		if data == nil {
+			return
+		}
+		glib.IdleAdd(func() bool {
+			if pixbuf := pixbufFromBytes(data, size); pixbuf != nil {
+				image.SetFromPixbuf(pixbuf)
+			}
+			return glib.SOURCE_REMOVE // =no need to call me again
+		})
+	}()
+}
+
+// pixbufFromBytes decodes an image, scaling it down if necessary so that
+// neither dimension exceeds maxSize. It returns nil if the image is invalid.
+func pixbufFromBytes(data []byte, maxSize int) *gdkpixbuf.Pixbuf {
+	loader := gdkpixbuf.NewPixbufLoader()
+	if loader == nil {
+		logger.Error("Failed to allocate pixbuf loader")
+		return nil
+	}
+	if err := loader.Write(data); err != nil {
+		logger.Error("loader.Write failed", "err", err)
+		return nil
+	}
+	if err := loader.Close(); err != nil {
+		logger.Error("loader.Close failed", "err", err)
+		return nil
+	}
+	pixbuf := loader.Pixbuf()
+	if pixbuf == nil {
+		logger.Error("loader.Pixbuf failed")
+		return nil
+	}
+
+	width := pixbuf.Width()
+	height := pixbuf.Height()
+	if (width > maxSize) || (height > maxSize) {
+		var destWidth, destHeight int
+		if width > height {
+			destWidth = maxSize
+			destHeight = height * destWidth / width
+		} else {
+			destHeight = maxSize
+			destWidth = width * destHeight / height
+		}
+		pixbuf = pixbuf.ScaleSimple(destWidth, destHeight, gdkpixbuf.InterpBilinear)
+	}
+	return pixbuf
+}
+
+// ShowQueue shows the play queue, fetching it from the server
+func (window *MainWindow) ShowQueue() {
+	window.setState(MainWindowStateQueue)
+	window.QueuePage.ShowMessage("Loading…")
+	window.refreshQueue()
+}
+
+// fetchInBackground calls fetch without blocking the UI, then passes its
+// results to show on the UI thread, unless we've switched server meanwhile
+func fetchInBackground[T any](window *MainWindow, fetch func(apiClient *apiclient.Client) (T, error), show func(T, error)) {
+	apiClient := window.ApiClient
+	go func() {
+		result, err := fetch(apiClient)
+		glib.IdleAdd(func() bool {
+			if apiClient == window.ApiClient {
+				show(result, err)
+			}
+			return glib.SOURCE_REMOVE // =no need to call me again
+		})
+	}()
+}
+
+func (window *MainWindow) refreshQueue() {
+	fetchInBackground(window, (*apiclient.Client).GetQueue, window.showQueue)
+}
+
+func (window *MainWindow) showQueue(queue []apiclient.QueueEntry, err error) {
+	page := window.QueuePage
+	if err != nil {
+		page.ShowMessage(err.Error())
+		return
+	}
+	if len(queue) == 0 {
+		page.ShowMessage("The queue is empty")
+		return
+	}
+	page.Clear()
+	for _, entry := range queue {
+		secondary := entry.Artist
+		if entry.Album != "" {
+			if secondary != "" {
+				secondary += " – "
+			}
+			secondary += entry.Album
+		}
+		page.AddRow(entry.Artwork, entry.Title, secondary, func() {
+			apiClient := window.ApiClient
+			window.runCommand(func() error { return apiClient.SendPlayQueuePos(entry.QueuePos) })
+			window.ShowControls()
+		})
+	}
+}
@@ -2,11 +2,18 @@ package mainwindow
 
 import (
 	"embed"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/url"
 	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+	"nsw42/piju-touchscreen-go/logging"
+	"os"
 	"slices"
 	"strconv"
+	"time"
 
 	"github.com/diamondburned/gotk4/pkg/gdk/v4"
 	"github.com/diamondburned/gotk4/pkg/gdkpixbuf/v2"
@@ -23,8 +30,20 @@ const (
 
 	maxImageSize = 300
 
+	// How long to wait for a slider to stop moving before acting on its value
+	seekDelayMs = 250
+
+	// How long to show error messages for
+	toastDurationMs = 4000
+
+	// Size of the shuffle and repeat buttons
+	modeButtonSize = 48
+
 	// Constants related to a fixed layout:
-	noTrackLabelW  float64 = 200
+	noTrackLabelW  float64 = 300
+	toastW         float64 = 600
+	toastH         float64 = 40
+	volumeH        float64 = 30
 	imgButtonW     float64 = 112
 	imgButtonH     float64 = 110
 	y1_padding     float64 = 20
@@ -37,14 +56,63 @@ type MainWindowState int
 const (
 	MainWindowStateControls MainWindowState = iota
 	MainWindowStateQRCode
+	MainWindowStateServerPicker
+	MainWindowStateQueue
+	MainWindowStateArtists
+	MainWindowStateAlbums
+	MainWindowStateTracks
+	MainWindowStateRadio
+	MainWindowStateSearch
+	MainWindowStatePlaylists
+	MainWindowStatePlaylistTracks
 )
 
+func (state MainWindowState) String() string {
+	switch state {
+	case MainWindowStateControls:
+		return "controls"
+	case MainWindowStateQRCode:
+		return "qrcode"
+	case MainWindowStateServerPicker:
+		return "servers"
+	case MainWindowStateQueue:
+		return "queue"
+	case MainWindowStateArtists:
+		return "artists"
+	case MainWindowStateAlbums:
+		return "albums"
+	case MainWindowStateTracks:
+		return "tracks"
+	case MainWindowStateRadio:
+		return "radio"
+	case MainWindowStateSearch:
+		return "search"
+	case MainWindowStatePlaylists:
+		return "playlists"
+	case MainWindowStatePlaylistTracks:
+		return "playlist-tracks"
+	}
+	return "???"
+}
+
 type MainWindow struct {
 	State             MainWindowState
 	ControlsContainer *gtk.Widget
 	QrCodeContainer   *gtk.Fixed
+	ServerPicker      *gtk.Box
+	ServerButtons     []*gtk.Button
+	QueuePage         *listPage
+	Library           *libraryPages
+	RadioPage         *listPage
+	SearchPage        *searchPage
+	Playlists         *playlistPages
+	RadioStations     []apiclient.RadioStation
+	FetchingStations  bool
+	Pages             map[MainWindowState]*listPage
 	ApiClient         *apiclient.Client
 	DarkMode          bool
+	ThemeFile         string // An extra stylesheet, or "" if none
+	ThemeProvider     *gtk.CSSProvider
 	Window            *gtk.ApplicationWindow
 	Artwork           *gtk.Image
 	TrackNameLabel    *gtk.Label
@@ -53,6 +121,16 @@ type MainWindow struct {
 	PrevButton        *gtk.Button
 	PlayPauseButton   *gtk.Button
 	NextButton        *gtk.Button
+	ShuffleButton     *gtk.Button
+	RepeatButton      *gtk.Button
+	ProgressContainer *gtk.Box
+	ElapsedLabel      *gtk.Label
+	ProgressScale     *gtk.Scale
+	RemainingLabel    *gtk.Label
+	VolumeContainer   *gtk.Box
+	VolumeDownButton  *gtk.Button
+	VolumeScale       *gtk.Scale
+	VolumeUpButton    *gtk.Button
 	MenuButton        *gtk.MenuButton
 	CloseButton       *gtk.Button
 	ScanningIndicator *gtk.Image
@@ -62,14 +140,29 @@ type MainWindow struct {
 	NextIcon          *gtk.Image
 	QrCodeIcon        *gtk.Image
 	// MenuIcon          *gtk.Image
+	Menu              *gio.Menu
 	MenuAction        *gio.SimpleAction
+	ServerAction      *gio.SimpleAction
 	PreviousWidth     int
 	PreviousHeight    int
 	HideMousePointer  bool
-	PlayPauseAction   func()
+	Toast             *gtk.Label
+	ToastTimeout      glib.SourceHandle
 	CurrentArtworkUri string
+	NowPlaying        apiclient.NowPlaying // As shown, including the effect of any pending command
+	ServerNowPlaying  apiclient.NowPlaying // As last reported by the server
+	PendingCommand    *pendingCommand
+	Searching         bool // Looking for a server to connect to
+	ConnectionState   apiclient.ConnectionState
+	RetryAt           time.Time
+	PendingSeek       time.Duration
+	SeekScheduled     bool
+	PendingVolume     int
+	VolumeScheduled   bool
 }
 
+var logger = logging.For("mainwindow")
+
 //go:embed icons/*.png
 var icons embed.FS
 
@@ -166,6 +259,16 @@ func mkLabel(justification gtk.Justification, large bool, darkMode bool) *gtk.La
 	return label
 }
 
+func mkSmallLabel(darkMode bool) *gtk.Label {
+	label := gtk.NewLabel("")
+	if darkMode {
+		label.AddCSSClass("piju-dark-small-label")
+	} else {
+		label.AddCSSClass("piju-light-small-label")
+	}
+	return label
+}
+
 func (window *MainWindow) layoutFixed() {
 	fixedContainer := gtk.NewFixed()
 	var xPadding, y0Padding, labelH float64
@@ -188,6 +291,12 @@ func (window *MainWindow) layoutFixed() {
 		label.SetSizeRequest(int(screenWidth-trackArtistX0-xPadding), int(labelH))
 	}
 
+	controlsContainer.Put(window.ProgressContainer, xPadding, artistY0+labelH)
+	window.ProgressContainer.SetSizeRequest(maxImageSize, int(volumeH))
+
+	controlsContainer.Put(window.VolumeContainer, trackArtistX0, artistY0+labelH)
+	window.VolumeContainer.SetSizeRequest(int(screenWidth-trackArtistX0-xPadding), int(volumeH))
+
 	controlsContainer.Put(window.NoTrackLabel, (screenWidth-noTrackLabelW)/2, 150)
 	window.NoTrackLabel.SetSizeRequest(int(noTrackLabelW), 32)
 	// buttons
@@ -199,13 +308,28 @@ func (window *MainWindow) layoutFixed() {
 	controlsContainer.Put(window.PrevButton, buttonXPadding, buttonY0)
 	controlsContainer.Put(window.PlayPauseButton, (screenWidth-imgButtonW)/2, buttonY0)
 	controlsContainer.Put(window.NextButton, screenWidth-buttonXPadding-imgButtonW, buttonY0)
+	// shuffle and repeat go in the space either side of prev and next
+	modeButtonY0 := buttonY0 + (imgButtonH-modeButtonSize)/2
+	controlsContainer.Put(window.ShuffleButton, (buttonXPadding-modeButtonSize)/2, modeButtonY0)
+	controlsContainer.Put(window.RepeatButton, screenWidth-(buttonXPadding+modeButtonSize)/2, modeButtonY0)
 
 	fixedContainer.Put(controlsContainer, 0, 0)
 
 	fixedContainer.Put(window.QrCodeContainer, 0, 0)
 
+	fixedContainer.Put(window.ServerPicker, 0, 0)
+	window.ServerPicker.SetSizeRequest(screenWidth, screenHeight)
+
+	for _, page := range window.Pages {
+		fixedContainer.Put(page.Container, 0, 0)
+		page.Container.SetSizeRequest(screenWidth, screenHeight)
+	}
+
 	fixedContainer.Put(window.ScanningIndicator, screenWidth-20, 4)
 
+	fixedContainer.Put(window.Toast, (screenWidth-toastW)/2, buttonY0-toastH-y1_padding)
+	window.Toast.SetSizeRequest(int(toastW), int(toastH))
+
 	fixedContainer.Put(window.MenuButton, 0, 0)
 
 	if window.CloseButton != nil {
@@ -233,6 +357,7 @@ func (window *MainWindow) layoutDynamic() {
 	trackArtistContainer := gtk.NewBox(gtk.OrientationVertical, margin)
 	trackArtistContainer.Append(window.TrackNameLabel)
 	trackArtistContainer.Append(window.ArtistLabel)
+	trackArtistContainer.Append(window.VolumeContainer)
 	trackArtistContainer.SetVExpand(true)
 
 	trackArtistContainer.SetMarginStart(margin)
@@ -246,27 +371,50 @@ func (window *MainWindow) layoutDynamic() {
 	topRowContainer.SetVAlign(gtk.AlignCenter)
 	topRowContainer.SetVExpand(true)
 
-	bottomRowContainer := gtk.NewBox(gtk.OrientationHorizontal, margin)
-	bottomRowContainer.Append(window.PrevButton)
-	bottomRowContainer.Append(window.PlayPauseButton)
-	bottomRowContainer.Append(window.NextButton)
+	transportContainer := gtk.NewBox(gtk.OrientationHorizontal, margin)
+	transportContainer.Append(window.PrevButton)
+	transportContainer.Append(window.PlayPauseButton)
+	transportContainer.Append(window.NextButton)
+	transportContainer.SetHExpand(true)
+	transportContainer.SetHomogeneous(true)
+
+	for _, button := range []*gtk.Button{window.ShuffleButton, window.RepeatButton} {
+		button.SetMarginStart(margin)
+		button.SetMarginEnd(margin)
+	}
+
+	bottomRowContainer := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	bottomRowContainer.Append(window.ShuffleButton)
+	bottomRowContainer.Append(transportContainer)
+	bottomRowContainer.Append(window.RepeatButton)
 	bottomRowContainer.SetVAlign(gtk.AlignStart)
 	bottomRowContainer.SetHExpand(true)
-	bottomRowContainer.SetHomogeneous(true)
+
+	window.ProgressContainer.SetMarginStart(margin)
+	window.ProgressContainer.SetMarginEnd(margin)
 
 	controlsContainer := gtk.NewBox(gtk.OrientationVertical, margin)
 	controlsContainer.Append(topRowContainer)
+	controlsContainer.Append(window.ProgressContainer)
 	controlsContainer.Append(bottomRowContainer)
 	controlsContainer.SetHomogeneous(false)
 	window.ControlsContainer = &controlsContainer.Widget
 
 	overlay := gtk.NewOverlay()
 	overlay.AddOverlay(window.QrCodeContainer) // ensure it's the bottom in the z-order
+	overlay.AddOverlay(window.ServerPicker)
+	for _, page := range window.Pages {
+		overlay.AddOverlay(page.Container)
+	}
 	window.ScanningIndicator.SetHAlign(gtk.AlignEnd)
 	window.ScanningIndicator.SetVAlign(gtk.AlignStart)
 	window.ScanningIndicator.SetMarginEnd(margin)
 	window.ScanningIndicator.SetMarginTop(margin)
 	overlay.AddOverlay(window.ScanningIndicator)
+	window.Toast.SetHAlign(gtk.AlignCenter)
+	window.Toast.SetVAlign(gtk.AlignEnd)
+	window.Toast.SetMarginBottom(margin)
+	overlay.AddOverlay(window.Toast)
 	window.MenuButton.SetHAlign(gtk.AlignStart)
 	window.MenuButton.SetVAlign(gtk.AlignStart)
 	window.MenuButton.SetMarginStart(margin / 2)
@@ -289,6 +437,7 @@ func NewMainWindow(app *gtk.Application,
 	fixedLayout bool,
 	closeButton bool,
 	hideMousePointer bool,
+	themeFile string,
 ) *MainWindow {
 
 	rtn := &MainWindow{}
@@ -314,6 +463,18 @@ func NewMainWindow(app *gtk.Application,
 	cssProvider := gtk.NewCSSProvider()
 	cssProvider.LoadFromString(cssString)
 	gtk.StyleContextAddProviderForDisplay(gdk.DisplayGetDefault(), cssProvider, gtk.STYLE_PROVIDER_PRIORITY_APPLICATION)
+	// The extra stylesheet, if any, overrides ours
+	rtn.ThemeFile = themeFile
+	rtn.ThemeProvider = gtk.NewCSSProvider()
+	rtn.ThemeProvider.ConnectParsingError(func(section *gtk.CSSSection, err error) {
+		logger.Error("Error in stylesheet", "section", section.String(), "err", err)
+	})
+	gtk.StyleContextAddProviderForDisplay(gdk.DisplayGetDefault(), rtn.ThemeProvider, gtk.STYLE_PROVIDER_PRIORITY_USER)
+	if themeFile != "" {
+		if err := rtn.ReloadTheme(); err != nil {
+			logger.Error("Unable to load stylesheet", "err", err)
+		}
+	}
 
 	rtn.Window = window
 
@@ -343,14 +504,69 @@ func NewMainWindow(app *gtk.Application,
 	rtn.NextButton.SetHAlign(gtk.AlignEnd)
 	rtn.NextButton.ConnectClicked(rtn.OnNext)
 
+	// Shuffle and repeat buttons
+	rtn.ShuffleButton = gtk.NewButtonFromIconName("media-playlist-shuffle-symbolic")
+	rtn.ShuffleButton.SetTooltipText("Shuffle")
+	rtn.ShuffleButton.ConnectClicked(rtn.OnShuffle)
+	rtn.RepeatButton = gtk.NewButtonFromIconName("media-playlist-repeat-symbolic")
+	rtn.RepeatButton.SetTooltipText("Repeat")
+	rtn.RepeatButton.ConnectClicked(rtn.OnRepeat)
+	for _, button := range []*gtk.Button{rtn.ShuffleButton, rtn.RepeatButton} {
+		button.SetFocusOnClick(false)
+		button.SetVAlign(gtk.AlignCenter)
+		button.SetSizeRequest(modeButtonSize, modeButtonSize)
+		if darkMode {
+			button.AddCSSClass("piju-dark-button")
+		}
+	}
+
+	// Track progress
+	rtn.ElapsedLabel = mkSmallLabel(darkMode)
+	rtn.ProgressScale = gtk.NewScaleWithRange(gtk.OrientationHorizontal, 0, 1, 1)
+	rtn.ProgressScale.SetDrawValue(false)
+	rtn.ProgressScale.SetHExpand(true)
+	rtn.ProgressScale.ConnectChangeValue(rtn.OnProgressChanged)
+	rtn.RemainingLabel = mkSmallLabel(darkMode)
+	rtn.ProgressContainer = gtk.NewBox(gtk.OrientationHorizontal, 4)
+	rtn.ProgressContainer.Append(rtn.ElapsedLabel)
+	rtn.ProgressContainer.Append(rtn.ProgressScale)
+	rtn.ProgressContainer.Append(rtn.RemainingLabel)
+	rtn.ProgressContainer.SetVAlign(gtk.AlignEnd)
+
+	// Volume controls
+	rtn.VolumeDownButton = gtk.NewButtonFromIconName("audio-volume-low-symbolic")
+	rtn.VolumeDownButton.ConnectClicked(rtn.OnVolumeDown)
+	rtn.VolumeScale = gtk.NewScaleWithRange(gtk.OrientationHorizontal, 0, 100, apiclient.VolumeStep)
+	rtn.VolumeScale.SetDrawValue(false)
+	rtn.VolumeScale.SetHExpand(true)
+	rtn.VolumeScale.ConnectChangeValue(rtn.OnVolumeChanged)
+	rtn.VolumeUpButton = gtk.NewButtonFromIconName("audio-volume-high-symbolic")
+	rtn.VolumeUpButton.ConnectClicked(rtn.OnVolumeUp)
+	rtn.VolumeContainer = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	rtn.VolumeContainer.Append(rtn.VolumeDownButton)
+	rtn.VolumeContainer.Append(rtn.VolumeScale)
+	rtn.VolumeContainer.Append(rtn.VolumeUpButton)
+	rtn.VolumeContainer.SetVAlign(gtk.AlignEnd)
+	for _, button := range []*gtk.Button{rtn.VolumeDownButton, rtn.VolumeUpButton} {
+		button.SetFocusOnClick(false)
+		if darkMode {
+			button.AddCSSClass("piju-dark-button")
+		}
+	}
+
 	// Menu button
 	rtn.MenuButton = gtk.NewMenuButton()
 	rtn.MenuButton.SetHAlign(gtk.AlignCenter)
 	menu := gio.NewMenu()
 	menu.Append("Local music", "app.resume('local')")
 	menu.Append("Radio", "app.resume('radio')")
+	menu.Append("Library", "app.resume('library')")
+	menu.Append("Playlists", "app.resume('playlists')")
+	menu.Append("Search", "app.resume('search')")
+	menu.Append("Queue", "app.resume('queue')")
 	menu.Append("Link", "app.resume('link')")
 	rtn.MenuButton.SetMenuModel(menu)
+	rtn.Menu = menu
 	rtn.MenuButton.Popover().SetHasArrow(false)
 	if darkMode {
 		rtn.MenuButton.AddCSSClass("piju-dark-button")
@@ -359,17 +575,34 @@ func NewMainWindow(app *gtk.Application,
 	app.ActionMap.AddAction(rtn.MenuAction)
 	rtn.MenuAction.ConnectActivate(func(param *glib.Variant) {
 		resumeType := param.String()
-		if resumeType == "link" {
+		switch resumeType {
+		case "link":
 			// touchscreen-only action: show the link QR code
-			rtn.State = MainWindowStateQRCode
-			rtn.ControlsContainer.SetVisible(false)
-			rtn.QrCodeContainer.SetVisible(true)
+			rtn.setState(MainWindowStateQRCode)
 			rtn.MenuAction.ChangeState(glib.NewVariantString("link"))
-		} else {
-			rtn.State = MainWindowStateControls
-			rtn.ControlsContainer.SetVisible(true)
-			rtn.QrCodeContainer.SetVisible(false)
-			rtn.ApiClient.SendResumeType(resumeType)
+		case "queue":
+			// touchscreen-only action: show the play queue
+			rtn.ShowQueue()
+			rtn.MenuAction.ChangeState(glib.NewVariantString("queue"))
+		case "radio":
+			rtn.ShowRadioStations()
+			rtn.MenuAction.ChangeState(glib.NewVariantString("radio"))
+		case "playlists":
+			// touchscreen-only action: browse the playlists
+			rtn.ShowPlaylists()
+			rtn.MenuAction.ChangeState(glib.NewVariantString("playlists"))
+		case "search":
+			// touchscreen-only action: search the library
+			rtn.ShowSearch()
+			rtn.MenuAction.ChangeState(glib.NewVariantString("search"))
+		case "library":
+			// touchscreen-only action: browse the library
+			rtn.ShowLibrary()
+			rtn.MenuAction.ChangeState(glib.NewVariantString("library"))
+		default:
+			rtn.setState(MainWindowStateControls)
+			apiClient := rtn.ApiClient
+			rtn.runCommand(func() error { return apiClient.SendResumeType(resumeType) })
 		}
 	})
 
@@ -386,6 +619,10 @@ func NewMainWindow(app *gtk.Application,
 
 	// Overlays
 	rtn.ScanningIndicator = loadLocalImageNoMode("circle", 16)
+	rtn.Toast = gtk.NewLabel("")
+	rtn.Toast.SetWrap(true)
+	rtn.Toast.AddCSSClass("piju-toast")
+	rtn.Toast.SetVisible(false)
 	if closeButton {
 		closeIcon := loadLocalImageNoMode("window-close", 0)
 		rtn.CloseButton = gtk.NewButton()
@@ -396,6 +633,31 @@ func NewMainWindow(app *gtk.Application,
 	// Layout and show
 	rtn.QrCodeContainer = gtk.NewFixed()
 	rtn.QrCodeContainer.SetVisible(false)
+	rtn.ServerPicker = gtk.NewBox(gtk.OrientationVertical, 20)
+	rtn.ServerPicker.SetVAlign(gtk.AlignCenter)
+	rtn.ServerPicker.SetHAlign(gtk.AlignCenter)
+	pickerTitle := mkLabel(gtk.JustifyCenter, true, darkMode)
+	pickerTitle.SetLabel("Choose a server")
+	pickerTitle.SetVExpand(false)
+	rtn.ServerPicker.Append(pickerTitle)
+	rtn.ServerPicker.SetVisible(false)
+	rtn.QueuePage = rtn.newListPage("Queue")
+	rtn.QueuePage.ShowThumbnails = true
+	rtn.Library = rtn.newLibraryPages()
+	rtn.RadioPage = rtn.newListPage("Radio")
+	rtn.RadioPage.ShowThumbnails = true
+	rtn.SearchPage = rtn.newSearchPage()
+	rtn.Playlists = rtn.newPlaylistPages()
+	rtn.Pages = map[MainWindowState]*listPage{
+		MainWindowStateQueue:          rtn.QueuePage,
+		MainWindowStateArtists:        rtn.Library.Artists,
+		MainWindowStateAlbums:         rtn.Library.Albums,
+		MainWindowStateTracks:         rtn.Library.Tracks,
+		MainWindowStateRadio:          rtn.RadioPage,
+		MainWindowStateSearch:         rtn.SearchPage.listPage,
+		MainWindowStatePlaylists:      rtn.Playlists.Playlists,
+		MainWindowStatePlaylistTracks: rtn.Playlists.Tracks,
+	}
 	if fixedLayout {
 		rtn.NoTrackLabel = mkLabel(gtk.JustifyCenter, false, darkMode)
 		rtn.layoutFixed()
@@ -404,25 +666,220 @@ func NewMainWindow(app *gtk.Application,
 		rtn.layoutDynamic()
 	}
 
+	swipe := gtk.NewGestureSwipe()
+	swipe.ConnectSwipe(rtn.OnSwipe)
+	window.AddController(swipe)
+
 	window.ConnectRealize(rtn.OnRealized)
 	window.SetVisible(true)
 
-	rtn.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Stopped})
+	glib.TimeoutAdd(1000, func() bool {
+		rtn.CheckWindowSize()
+		rtn.UpdateProgress()
+		rtn.UpdateConnectionCountdown()
+		return glib.SOURCE_CONTINUE // =please keep calling me
+	})
+
+	rtn.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Stopped, Volume: apiclient.UnknownVolume})
 	return rtn
 }
 
+// runCommand sends a command to the server without blocking the UI,
+// reporting any failure in a toast. command runs in the background, so must
+// not read the window's fields: capture window.ApiClient before calling this.
+func (window *MainWindow) runCommand(command func() error) {
+	go func() {
+		if err := command(); err != nil {
+			glib.IdleAdd(func() bool {
+				window.ShowToast(err.Error())
+				return glib.SOURCE_REMOVE // =no need to call me again
+			})
+		}
+	}()
+}
+
+// ShowToast briefly shows a message over the controls
+func (window *MainWindow) ShowToast(message string) {
+	if window.ToastTimeout != 0 {
+		glib.SourceRemove(window.ToastTimeout)
+	}
+	window.Toast.SetLabel(message)
+	window.Toast.SetVisible(true)
+	window.ToastTimeout = glib.TimeoutAdd(toastDurationMs, func() bool {
+		window.Toast.SetVisible(false)
+		window.ToastTimeout = 0
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
 func (window *MainWindow) OnNext() {
-	window.ApiClient.SendNext()
+	if window.NowPlaying.StreamName != "" {
+		window.stepRadioStation(1)
+		return
+	}
+	window.runOptimisticCommand(optimisticSkip(window.NowPlaying, 1, window.NextButton), window.perform(frontend.Next))
 }
 
 func (window *MainWindow) OnPlayPause() {
-	if window.PlayPauseAction != nil {
-		window.PlayPauseAction()
+	var expected apiclient.Status
+	switch window.NowPlaying.Status {
+	case apiclient.Playing:
+		expected = apiclient.Paused
+	case apiclient.Paused:
+		expected = apiclient.Playing
+	default:
+		return
 	}
+	window.runOptimisticCommand(optimisticPlayPause(expected, window.PlayPauseButton), window.perform(frontend.PlayPause))
 }
 
 func (window *MainWindow) OnPrevious() {
-	window.ApiClient.SendPrevious()
+	if window.NowPlaying.StreamName != "" {
+		window.stepRadioStation(-1)
+		return
+	}
+	window.runOptimisticCommand(optimisticSkip(window.NowPlaying, -1, window.PrevButton), window.perform(frontend.Previous))
+}
+
+// OnShuffle asks the server to switch shuffle on or off. The button doesn't
+// change until the server reports the new state.
+func (window *MainWindow) OnShuffle() {
+	window.runCommand(window.perform(frontend.Shuffle))
+}
+
+// OnRepeat asks the server to switch repeat on or off. The button doesn't
+// change until the server reports the new state.
+func (window *MainWindow) OnRepeat() {
+	window.runCommand(window.perform(frontend.Repeat))
+}
+
+func (window *MainWindow) OnVolumeDown() {
+	window.runCommand(window.perform(frontend.VolumeDown))
+}
+
+func (window *MainWindow) OnVolumeUp() {
+	window.runCommand(window.perform(frontend.VolumeUp))
+}
+
+// perform returns a command that carries out intent, as the terminal UI
+// does, for runCommand. The client and status are read now, on the UI thread.
+func (window *MainWindow) perform(intent frontend.Intent) func() error {
+	apiClient := window.ApiClient
+	nowPlaying := window.NowPlaying
+	return func() error { return frontend.Perform(apiClient, intent, nowPlaying) }
+}
+
+func (window *MainWindow) OnVolumeChanged(scroll gtk.ScrollType, value float64) bool {
+	// As for seeking, only send the volume once the slider settles, so that
+	// commands can't arrive out of order and leave the wrong volume set
+	window.PendingVolume = int(value + 0.5)
+	if !window.VolumeScheduled {
+		window.VolumeScheduled = true
+		glib.TimeoutAdd(seekDelayMs, func() bool {
+			window.VolumeScheduled = false
+			apiClient := window.ApiClient
+			volume := window.PendingVolume
+			window.runCommand(func() error { return apiClient.SetVolume(volume) })
+			return glib.SOURCE_REMOVE // =no need to call me again
+		})
+	}
+	return false // allow the default handler to move the slider
+}
+
+func (window *MainWindow) OnProgressChanged(scroll gtk.ScrollType, value float64) bool {
+	// Dragging the slider generates a stream of changes: only seek once it settles
+	window.PendingSeek = time.Duration(value * float64(time.Second))
+	if !window.SeekScheduled {
+		window.SeekScheduled = true
+		glib.TimeoutAdd(seekDelayMs, func() bool {
+			window.SeekScheduled = false
+			position := window.PendingSeek
+			apiClient := window.ApiClient
+			window.runCommand(func() error { return apiClient.SendSeek(position) })
+			// Assume the seek succeeded until the server tells us otherwise
+			window.NowPlaying.Position = window.PendingSeek
+			window.NowPlaying.PositionTime = time.Now()
+			window.UpdateProgress()
+			return glib.SOURCE_REMOVE // =no need to call me again
+		})
+	}
+	return false // allow the default handler to move the slider
+}
+
+// swipeVelocity is how fast, in pixels per second, a horizontal drag must be
+// to count as a swipe
+const swipeVelocity = 500
+
+// OnSwipe moves between the controls and the queue: swiping left from the
+// controls shows the queue, and swiping right from any page goes back
+func (window *MainWindow) OnSwipe(velocityX, velocityY float64) {
+	if math.Abs(velocityX) < swipeVelocity || math.Abs(velocityX) < 2*math.Abs(velocityY) {
+		// Too slow, or mostly vertical, e.g. scrolling a list
+		return
+	}
+	if velocityX < 0 && window.State == MainWindowStateControls {
+		window.ShowQueue()
+	} else if velocityX > 0 {
+		if page, ok := window.Pages[window.State]; ok {
+			page.OnBack()
+		}
+	}
+}
+
+// setState shows the page for the given state, hiding all the others
+func (window *MainWindow) setState(state MainWindowState) {
+	window.State = state
+	window.ControlsContainer.SetVisible(state == MainWindowStateControls)
+	window.QrCodeContainer.SetVisible(state == MainWindowStateQRCode)
+	window.ServerPicker.SetVisible(state == MainWindowStateServerPicker)
+	for pageState, page := range window.Pages {
+		page.Container.SetVisible(state == pageState)
+	}
+}
+
+// ShowControls returns to the main, now playing, page
+func (window *MainWindow) ShowControls() {
+	window.setState(MainWindowStateControls)
+	window.showNowPlayingLocalRadio(window.NowPlaying)
+}
+
+// ShowPage shows the page with the given name, as returned by
+// MainWindowState.String. Only pages that don't depend on an earlier choice,
+// such as an artist, can be shown.
+func (window *MainWindow) ShowPage(page string) error {
+	switch page {
+	case MainWindowStateControls.String():
+		window.ShowControls()
+	case MainWindowStateQRCode.String():
+		window.setState(MainWindowStateQRCode)
+	case MainWindowStateQueue.String():
+		window.ShowQueue()
+	case MainWindowStateArtists.String():
+		window.ShowLibrary()
+	case MainWindowStateRadio.String():
+		window.ShowRadioStations()
+	case MainWindowStateSearch.String():
+		window.ShowSearch()
+	case MainWindowStatePlaylists.String():
+		window.ShowPlaylists()
+	default:
+		return fmt.Errorf("Unable to show page %q", page)
+	}
+	return nil
+}
+
+// ReloadTheme reloads the extra stylesheet, so that changes to it take
+// effect without restarting
+func (window *MainWindow) ReloadTheme() error {
+	if window.ThemeFile == "" {
+		return errors.New("No stylesheet to reload")
+	}
+	css, err := os.ReadFile(window.ThemeFile)
+	if err != nil {
+		return err
+	}
+	window.ThemeProvider.LoadFromString(string(css))
+	return nil
 }
 
 func (window *MainWindow) OnQuit() {
@@ -483,7 +940,7 @@ func (window *MainWindow) NewQRCode(size int) *gtk.Image {
 	webuiUrl := webui.String()
 	png, err := qrcode.Encode(webuiUrl, qrcode.Medium, size)
 	if err != nil {
-		log.Println("Error generating QR code:", err)
+		logger.Error("Error generating QR code", "err", err)
 	}
 	return imageFromPNGBytes(png)
 }
@@ -493,13 +950,137 @@ func (window *MainWindow) showConnectionError() {
 	window.TrackNameLabel.SetVisible(false)
 	window.Artwork.SetVisible(false)
 	window.NoTrackLabel.SetVisible(true)
-	window.NoTrackLabel.SetLabel("Connection error")
+	window.NoTrackLabel.SetLabel(window.connectionErrorText())
 	window.ScanningIndicator.SetVisible(false)
 	window.PlayIcon.SetVisible(true)
 	window.PauseIcon.SetVisible(false)
 	window.PrevButton.SetSensitive(false)
 	window.PlayPauseButton.SetSensitive(false)
 	window.NextButton.SetSensitive(false)
+	window.VolumeContainer.SetSensitive(false)
+	window.ProgressContainer.SetVisible(false)
+	window.ShuffleButton.SetSensitive(false)
+	window.RepeatButton.SetSensitive(false)
+}
+
+func (window *MainWindow) connectionErrorText() string {
+	if window.Searching {
+		return "Searching for server…"
+	}
+	switch window.ConnectionState {
+	case apiclient.Connecting:
+		return "Connecting…"
+	case apiclient.Backoff:
+		seconds := int(time.Until(window.RetryAt).Seconds() + 0.999)
+		if seconds > 0 {
+			return "Reconnecting in " + strconv.Itoa(seconds) + " s"
+		}
+		return "Reconnecting…"
+	}
+	return "Connection error"
+}
+
+// ShowSearching shows whether we are looking for a server to connect to
+func (window *MainWindow) ShowSearching(searching bool) {
+	window.Searching = searching
+	if searching {
+		window.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Error})
+	}
+}
+
+// ShowServerPicker asks the user which of several servers to connect to
+func (window *MainWindow) ShowServerPicker(servers []apiclient.Server, onPicked func(index int)) {
+	for _, button := range window.ServerButtons {
+		window.ServerPicker.Remove(button)
+	}
+	window.ServerButtons = nil
+	for index, server := range servers {
+		button := gtk.NewButtonWithLabel(server.Name)
+		button.SetTooltipText(server.Host)
+		button.SetFocusOnClick(false)
+		button.SetSizeRequest(400, 60)
+		if window.DarkMode {
+			button.AddCSSClass("piju-dark-button")
+		}
+		button.ConnectClicked(func() {
+			window.setState(MainWindowStateControls)
+			onPicked(index)
+		})
+		window.ServerPicker.Append(button)
+		window.ServerButtons = append(window.ServerButtons, button)
+	}
+	window.setState(MainWindowStateServerPicker)
+}
+
+// SetServers adds a section to the menu to switch between the given
+// servers, if there is more than one
+func (window *MainWindow) SetServers(servers []apiclient.Server, onSwitch func(index int)) {
+	if len(servers) < 2 || window.ServerAction != nil {
+		return
+	}
+	section := gio.NewMenu()
+	for index, server := range servers {
+		section.Append(server.Name, "app.server('"+strconv.Itoa(index)+"')")
+	}
+	window.Menu.AppendSection("Server", section)
+	window.ServerAction = gio.NewSimpleActionStateful("server", glib.NewVariantType("s"), glib.NewVariantString("0"))
+	window.Window.Application().ActionMap.AddAction(window.ServerAction)
+	window.ServerAction.ConnectActivate(func(param *glib.Variant) {
+		index, err := strconv.Atoi(param.String())
+		if err != nil {
+			return
+		}
+		window.ServerAction.ChangeState(param)
+		onSwitch(index)
+	})
+}
+
+// ShowActiveServer updates the menu to show which server is active
+func (window *MainWindow) ShowActiveServer(index int) {
+	if window.ServerAction != nil {
+		window.ServerAction.ChangeState(glib.NewVariantString(strconv.Itoa(index)))
+	}
+}
+
+// SetApiClient switches to a different server
+func (window *MainWindow) SetApiClient(apiClient *apiclient.Client) {
+	window.ApiClient = apiClient
+	window.Searching = false
+	if window.State == MainWindowStateServerPicker {
+		// The server was chosen some other way
+		window.setState(MainWindowStateControls)
+	}
+	window.cancelPendingCommand()
+	window.RadioStations = nil
+	window.FetchingStations = false
+	window.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Error})
+	if window.PreviousWidth > 0 && window.PreviousHeight > 0 {
+		// Regenerate the QR code for the new server
+		window.Resized(window.PreviousWidth, window.PreviousHeight)
+	}
+}
+
+func (window *MainWindow) QueueShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	glib.IdleAdd(func() bool {
+		window.ShowConnectionState(state, retryAt)
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (window *MainWindow) ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	window.ConnectionState = state
+	window.RetryAt = retryAt
+	if state != apiclient.Connected {
+		window.ShowNowPlaying(apiclient.NowPlaying{Status: apiclient.Error})
+	}
+}
+
+// UpdateConnectionCountdown refreshes the time until the next connection
+// attempt, if one is pending. It should be called once a second.
+func (window *MainWindow) UpdateConnectionCountdown() {
+	if window.ConnectionState == apiclient.Backoff && window.NowPlaying.Status == apiclient.Error {
+		window.NoTrackLabel.SetLabel(window.connectionErrorText())
+	}
 }
 
 func (window *MainWindow) QueueShowNowPlaying(nowPlaying apiclient.NowPlaying) {
@@ -509,7 +1090,23 @@ func (window *MainWindow) QueueShowNowPlaying(nowPlaying apiclient.NowPlaying) {
 	})
 }
 
+// ShowNowPlaying shows a status received from the server
 func (window *MainWindow) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
+	previous := window.ServerNowPlaying
+	window.ServerNowPlaying = nowPlaying
+	if window.State == MainWindowStateQueue && nowPlaying.Status != apiclient.Error &&
+		(nowPlaying.TrackNumber != previous.TrackNumber || nowPlaying.AlbumTracks != previous.AlbumTracks) {
+		// The queue has moved on, or been changed
+		window.refreshQueue()
+	}
+	if window.PendingCommand != nil {
+		nowPlaying = window.reconcile(nowPlaying)
+	}
+	window.showNowPlaying(nowPlaying)
+}
+
+func (window *MainWindow) showNowPlaying(nowPlaying apiclient.NowPlaying) {
+	window.NowPlaying = nowPlaying
 	if nowPlaying.Status == apiclient.Error {
 		window.showConnectionError()
 	} else {
@@ -518,7 +1115,21 @@ func (window *MainWindow) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
 		window.showNowPlayingPlayPauseIcon(nowPlaying)
 		window.showNowPlayingPrevNext(nowPlaying)
 		window.showNowPlayingLocalRadio(nowPlaying)
+		window.showNowPlayingVolume(nowPlaying)
+		window.showNowPlayingModes(nowPlaying)
+		window.showNowPlayingProgress(nowPlaying)
 		window.ScanningIndicator.SetVisible(nowPlaying.Scanning)
+		window.showPendingTrackChange()
+	}
+}
+
+func (window *MainWindow) showPendingTrackChange() {
+	opacity := 1.0
+	if window.PendingCommand != nil && window.PendingCommand.dimsTrack {
+		opacity = 0.5
+	}
+	for _, widget := range []*gtk.Widget{&window.TrackNameLabel.Widget, &window.ArtistLabel.Widget, &window.Artwork.Widget} {
+		widget.SetOpacity(opacity)
 	}
 }
 
@@ -571,38 +1182,10 @@ func (window *MainWindow) showNowPlayingImageInner(nowPlaying apiclient.NowPlayi
 		return false
 	}
 
-	loader := gdkpixbuf.NewPixbufLoader()
-	if loader == nil {
-		log.Println("Failed to allocate pixbuf loader")
-		return false
-	}
-	if err := loader.Write(nowPlaying.Artwork); err != nil {
-		log.Println("loader.Write failed:", err.Error())
-		return false
-	}
-	if err := loader.Close(); err != nil {
-		log.Println("loader.Close failed:", err.Error())
-		return false
-	}
-	pixbuf := loader.Pixbuf()
+	pixbuf := pixbufFromBytes(nowPlaying.Artwork, maxImageSize)
 	if pixbuf == nil {
-		log.Println("loader.Pixbuf failed")
 		return false
 	}
-
-	width := pixbuf.Width()
-	height := pixbuf.Height()
-	if (width > maxImageSize) || (height > maxImageSize) {
-		var destWidth, destHeight int
-		if width > height {
-			destWidth = maxImageSize
-			destHeight = height * destWidth / width
-		} else {
-			destHeight = maxImageSize
-			destWidth = width * destHeight / height
-		}
-		pixbuf = pixbuf.ScaleSimple(destWidth, destHeight, gdkpixbuf.InterpBilinear)
-	}
 	window.Artwork.SetFromPixbuf(pixbuf)
 	window.Artwork.SetVisible(true)
 	return true
@@ -623,21 +1206,17 @@ func (window *MainWindow) showNowPlayingLocalRadio(nowPlaying apiclient.NowPlayi
 func (window *MainWindow) showNowPlayingPlayPauseIcon(nowPlaying apiclient.NowPlaying) {
 	var sensitive bool
 	var icon *gtk.Image
-	var action func()
 
 	switch nowPlaying.Status {
 	case apiclient.Stopped:
 		sensitive = false
 		icon = window.PlayIcon
-		action = nil
 	case apiclient.Playing:
 		sensitive = true
 		icon = window.PauseIcon
-		action = window.ApiClient.SendPause
 	case apiclient.Paused:
 		sensitive = true
 		icon = window.PlayIcon
-		action = window.ApiClient.SendResume
 	}
 	if icon == nil {
 		// We're not yet fully initialised
@@ -652,12 +1231,77 @@ func (window *MainWindow) showNowPlayingPlayPauseIcon(nowPlaying apiclient.NowPl
 	}
 	otherIcon.SetVisible(false)
 	window.PlayPauseButton.SetSensitive(sensitive)
-	window.PlayPauseAction = action
 }
 
 func (window *MainWindow) showNowPlayingPrevNext(nowPlaying apiclient.NowPlaying) {
+	if nowPlaying.StreamName != "" {
+		// Step through the radio stations
+		if window.RadioStations == nil && !window.FetchingStations {
+			window.fetchRadioStations(func(error) {})
+		}
+		canStep := len(window.RadioStations) > 1
+		window.PrevButton.SetSensitive(canStep)
+		window.NextButton.SetSensitive(canStep)
+		return
+	}
 	window.PrevButton.SetSensitive(nowPlaying.TrackNumber > 1)
 	window.NextButton.SetSensitive(nowPlaying.TrackNumber > 0 &&
 		nowPlaying.AlbumTracks > 0 &&
 		nowPlaying.TrackNumber < nowPlaying.AlbumTracks)
 }
+
+func (window *MainWindow) showNowPlayingVolume(nowPlaying apiclient.NowPlaying) {
+	if nowPlaying.Volume == apiclient.UnknownVolume {
+		window.VolumeContainer.SetSensitive(false)
+		return
+	}
+	window.VolumeContainer.SetSensitive(true)
+	window.VolumeScale.SetValue(float64(nowPlaying.Volume))
+	window.VolumeDownButton.SetSensitive(nowPlaying.Volume > 0)
+	window.VolumeUpButton.SetSensitive(nowPlaying.Volume < 100)
+}
+
+func (window *MainWindow) showNowPlayingModes(nowPlaying apiclient.NowPlaying) {
+	for _, mode := range []struct {
+		button *gtk.Button
+		mode   apiclient.Mode
+	}{
+		{window.ShuffleButton, nowPlaying.Shuffle},
+		{window.RepeatButton, nowPlaying.Repeat},
+	} {
+		mode.button.SetSensitive(mode.mode != apiclient.ModeUnknown)
+		if mode.mode == apiclient.ModeOn {
+			mode.button.AddCSSClass("piju-mode-on")
+		} else {
+			mode.button.RemoveCSSClass("piju-mode-on")
+		}
+	}
+}
+
+func (window *MainWindow) showNowPlayingProgress(nowPlaying apiclient.NowPlaying) {
+	if nowPlaying.Duration > 0 {
+		window.ProgressScale.SetRange(0, nowPlaying.Duration.Seconds())
+	}
+	window.UpdateProgress()
+}
+
+// UpdateProgress refreshes the progress bar and elapsed/remaining time,
+// interpolating from the most recent status. It should be called regularly
+// while a track is playing.
+func (window *MainWindow) UpdateProgress() {
+	nowPlaying := window.NowPlaying
+	if nowPlaying.Status == apiclient.Error || !nowPlaying.IsTrack || nowPlaying.Duration <= 0 || !nowPlaying.PositionKnown {
+		// Without the position, the bar would jump back to the start with
+		// every status update
+		window.ProgressContainer.SetVisible(false)
+		return
+	}
+	window.ProgressContainer.SetVisible(true)
+	elapsed := nowPlaying.ElapsedAt(time.Now())
+	if !window.SeekScheduled {
+		// Don't fight the user if they're dragging the slider
+		window.ProgressScale.SetValue(elapsed.Seconds())
+	}
+	window.ElapsedLabel.SetLabel(frontend.FormatDuration(elapsed))
+	window.RemainingLabel.SetLabel("-" + frontend.FormatDuration(nowPlaying.Duration-elapsed))
+}
@@ -0,0 +1,163 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+const (
+	// How long to wait for the server to confirm a command, once it has
+	// accepted it, before giving up on the optimistic update
+	reconcileTimeoutMs = 3000
+
+	// How long to highlight a button whose optimistic update was rolled back
+	rollbackHintMs = 1000
+
+	// How close to the start of a track the position must be for the track
+	// to count as having been restarted
+	restartTolerance = 2 * time.Second
+)
+
+// pendingCommand is a command whose expected effect is already shown in
+// the UI, but which the server has not yet confirmed
+type pendingCommand struct {
+	// apply returns the given status with the command's effect applied
+	apply func(apiclient.NowPlaying) apiclient.NowPlaying
+	// confirms returns whether a status from the server shows the command took effect
+	confirms func(apiclient.NowPlaying) bool
+	// button is highlighted if the update has to be rolled back
+	button *gtk.Button
+	// dimsTrack is set if the track details shown are known to be out of date
+	dimsTrack bool
+	// accepted is set once the server has replied successfully to the command
+	accepted bool
+	timeout  glib.SourceHandle
+}
+
+// runOptimisticCommand immediately shows the effect of a command, then
+// sends it to the server. The next status from the server either confirms
+// the update, or causes it to be rolled back.
+func (window *MainWindow) runOptimisticCommand(pending *pendingCommand, command func() error) {
+	window.cancelPendingCommand()
+	window.PendingCommand = pending
+	window.showNowPlaying(pending.apply(window.ServerNowPlaying))
+
+	go func() {
+		err := command()
+		glib.IdleAdd(func() bool {
+			if window.PendingCommand != pending {
+				// Already superseded or reconciled
+				return glib.SOURCE_REMOVE
+			}
+			if err != nil {
+				window.rollBack()
+				window.ShowToast(err.Error())
+				return glib.SOURCE_REMOVE
+			}
+			pending.accepted = true
+			pending.timeout = glib.TimeoutAdd(reconcileTimeoutMs, func() bool {
+				pending.timeout = 0
+				if window.PendingCommand == pending {
+					window.rollBack()
+				}
+				return glib.SOURCE_REMOVE // =no need to call me again
+			})
+			return glib.SOURCE_REMOVE // =no need to call me again
+		})
+	}()
+}
+
+// reconcile decides what to show, given a new status from the server and
+// a pending command
+func (window *MainWindow) reconcile(nowPlaying apiclient.NowPlaying) apiclient.NowPlaying {
+	pending := window.PendingCommand
+	switch {
+	case nowPlaying.Status == apiclient.Error:
+		// Nothing to reconcile with: just show the error
+		window.cancelPendingCommand()
+		return nowPlaying
+	case pending.confirms(nowPlaying):
+		window.cancelPendingCommand()
+		return nowPlaying
+	case !pending.accepted:
+		// This status may predate the command: keep showing its effect
+		return pending.apply(nowPlaying)
+	}
+	// The server has accepted the command, yet disagrees about its effect
+	window.cancelPendingCommand()
+	window.showRollbackHint(pending.button)
+	return nowPlaying
+}
+
+func (window *MainWindow) rollBack() {
+	pending := window.PendingCommand
+	window.cancelPendingCommand()
+	window.showNowPlaying(window.ServerNowPlaying)
+	window.showRollbackHint(pending.button)
+}
+
+func (window *MainWindow) cancelPendingCommand() {
+	pending := window.PendingCommand
+	if pending == nil {
+		return
+	}
+	if pending.timeout != 0 {
+		glib.SourceRemove(pending.timeout)
+		pending.timeout = 0
+	}
+	window.PendingCommand = nil
+}
+
+func (window *MainWindow) showRollbackHint(button *gtk.Button) {
+	button.AddCSSClass("piju-rolled-back")
+	glib.TimeoutAdd(rollbackHintMs, func() bool {
+		button.RemoveCSSClass("piju-rolled-back")
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func optimisticPlayPause(expected apiclient.Status, button *gtk.Button) *pendingCommand {
+	return &pendingCommand{
+		apply: func(nowPlaying apiclient.NowPlaying) apiclient.NowPlaying {
+			nowPlaying.Status = expected
+			return nowPlaying
+		},
+		confirms: func(nowPlaying apiclient.NowPlaying) bool {
+			return nowPlaying.Status == expected
+		},
+		button: button,
+	}
+}
+
+// optimisticSkip predicts the effect of skipping by delta tracks. We can't
+// know the details of the new track, so just the track number changes.
+// The server may instead restart the current track, e.g. for "previous" part
+// way through a track, so a reported position back at the start also
+// confirms it.
+func optimisticSkip(current apiclient.NowPlaying, delta int, button *gtk.Button) *pendingCommand {
+	expected := current.TrackNumber + delta
+	skipTime := time.Now()
+	return &pendingCommand{
+		apply: func(nowPlaying apiclient.NowPlaying) apiclient.NowPlaying {
+			nowPlaying.TrackNumber = expected
+			nowPlaying.Position = 0
+			nowPlaying.PositionTime = skipTime
+			return nowPlaying
+		},
+		confirms: func(nowPlaying apiclient.NowPlaying) bool {
+			if nowPlaying.TrackNumber == expected || nowPlaying.TrackName != current.TrackName {
+				return true
+			}
+			// Without a reported position, every status would look like a
+			// restart, even one sent before the command took effect
+			return nowPlaying.PositionKnown && current.PositionKnown &&
+				nowPlaying.Position <= restartTolerance &&
+				nowPlaying.Position < current.ElapsedAt(nowPlaying.PositionTime)
+		},
+		button:    button,
+		dimsTrack: true,
+	}
+}
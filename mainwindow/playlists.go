@@ -0,0 +1,108 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+// playlistPages are the pages used to browse playlists: the list of them,
+// then the tracks in one of them
+type playlistPages struct {
+	Playlists     *listPage
+	Tracks        *listPage
+	PlayAllButton *gtk.Button
+	Playlist      apiclient.Playlist // The playlist whose tracks are shown
+}
+
+func (window *MainWindow) newPlaylistPages() *playlistPages {
+	playlists := &playlistPages{
+		Playlists: window.newListPage("Playlists"),
+		Tracks:    window.newListPage("Playlist"),
+	}
+	playlists.Tracks.OnBack = func() { window.setState(MainWindowStatePlaylists) }
+
+	playlists.PlayAllButton = gtk.NewButtonWithLabel("Play all")
+	playlists.PlayAllButton.SetFocusOnClick(false)
+	playlists.PlayAllButton.SetVisible(false)
+	playlists.PlayAllButton.ConnectClicked(func() {
+		playlist := playlists.Playlist
+		apiClient := window.ApiClient
+		window.runCommand(func() error { return apiClient.SendPlayPlaylist(playlist) })
+		window.ShowControls()
+	})
+	if window.DarkMode {
+		playlists.PlayAllButton.AddCSSClass("piju-dark-button")
+	}
+	playlists.Tracks.Header.Append(playlists.PlayAllButton)
+	return playlists
+}
+
+// ShowPlaylists shows the list of playlists to choose from
+func (window *MainWindow) ShowPlaylists() {
+	page := window.Playlists.Playlists
+	window.setState(MainWindowStatePlaylists)
+	page.ShowMessage("Loading…")
+	fetchInBackground(window, (*apiclient.Client).GetPlaylists, func(playlists []apiclient.Playlist, err error) {
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		if len(playlists) == 0 {
+			page.ShowMessage("No playlists")
+			return
+		}
+		page.Clear()
+		for _, playlist := range playlists {
+			page.AddRow("", playlist.Title, "", func() { window.showPlaylist(playlist) })
+		}
+	})
+}
+
+func (window *MainWindow) showPlaylist(playlist apiclient.Playlist) {
+	playlists := window.Playlists
+	page := playlists.Tracks
+	page.Title.SetLabel(playlist.Title)
+	playlists.PlayAllButton.SetVisible(false)
+	window.setState(MainWindowStatePlaylistTracks)
+	page.ShowMessage("Loading…")
+	page.generation++
+	generation := page.generation
+	fetch := func(apiClient *apiclient.Client) (apiclient.Playlist, error) {
+		return apiClient.GetPlaylist(playlist.Link)
+	}
+	fetchInBackground(window, fetch, func(playlist apiclient.Playlist, err error) {
+		if generation != page.generation {
+			// Another playlist has been chosen since, so this one mustn't be
+			// the one that "Play all" plays
+			return
+		}
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		playlists.Playlist = playlist
+		if len(playlist.Tracks) == 0 {
+			page.ShowMessage("The playlist is empty")
+			return
+		}
+		playlists.PlayAllButton.SetVisible(true)
+		page.Clear()
+		for index, track := range playlist.Tracks {
+			details := track.Artist
+			if track.Duration > 0 {
+				if details != "" {
+					details += "  "
+				}
+				details += frontend.FormatDuration(time.Duration(track.Duration * float64(time.Second)))
+			}
+			page.AddRow("", track.Title, details, func() {
+				apiClient := window.ApiClient
+				window.runCommand(func() error { return apiClient.SendPlayPlaylistFromTrack(playlist, index) })
+				window.ShowControls()
+			})
+		}
+	})
+}
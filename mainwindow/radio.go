@@ -0,0 +1,74 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+)
+
+// ShowRadioStations shows the list of radio stations to choose from
+func (window *MainWindow) ShowRadioStations() {
+	page := window.RadioPage
+	window.setState(MainWindowStateRadio)
+	page.ShowMessage("Loading…")
+	window.fetchRadioStations(func(err error) {
+		if err != nil {
+			page.ShowMessage(err.Error())
+			return
+		}
+		if len(window.RadioStations) == 0 {
+			page.ShowMessage("No radio stations")
+			return
+		}
+		page.Clear()
+		for _, station := range window.RadioStations {
+			var details string
+			if station.Name == window.NowPlaying.StreamName {
+				details = "Now playing"
+			}
+			page.AddRow(station.Artwork, station.Name, details, func() {
+				window.playRadioStation(station)
+				window.ShowControls()
+			})
+		}
+	})
+}
+
+// fetchRadioStations updates the list of stations in the background, then
+// calls done on the UI thread
+func (window *MainWindow) fetchRadioStations(done func(err error)) {
+	window.FetchingStations = true
+	fetchInBackground(window, (*apiclient.Client).GetRadioStations, func(stations []apiclient.RadioStation, err error) {
+		window.FetchingStations = false
+		if err == nil {
+			window.RadioStations = stations
+			window.showNowPlayingPrevNext(window.NowPlaying)
+		}
+		done(err)
+	})
+}
+
+func (window *MainWindow) playRadioStation(station apiclient.RadioStation) {
+	apiClient := window.ApiClient
+	window.runCommand(func() error { return apiClient.SendPlayRadioStation(station) })
+}
+
+// stepRadioStation plays the station delta places after the current one in
+// the list, wrapping around at either end
+func (window *MainWindow) stepRadioStation(delta int) {
+	count := len(window.RadioStations)
+	if count == 0 {
+		return
+	}
+	index := -1
+	for i, station := range window.RadioStations {
+		if station.Name == window.NowPlaying.StreamName {
+			index = i
+			break
+		}
+	}
+	if index < 0 && delta < 0 {
+		// Not a station we know: step back from the start of the list, to the end
+		index = 0
+	}
+	index = ((index+delta)%count + count) % count
+	window.playRadioStation(window.RadioStations[index])
+}
@@ -0,0 +1,175 @@
+package mainwindow
+
+import (
+	"nsw42/piju-touchscreen-go/apiclient"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+const (
+	// How long to wait for typing to pause before searching
+	searchDelayMs = 400
+
+	// Searches shorter than this match too much to be useful
+	minSearchLength = 2
+
+	keyW = 68
+	keyH = 44
+)
+
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl'",
+	"zxcvbnm,.-",
+}
+
+// searchPage is a page of search results, with an on-screen keyboard to
+// type the query
+type searchPage struct {
+	*listPage
+	Entry    *gtk.Entry
+	Keyboard *gtk.Box
+	timeout  glib.SourceHandle
+}
+
+func (window *MainWindow) newSearchPage() *searchPage {
+	page := &searchPage{listPage: window.newListPage("Search")}
+	page.Title.SetVisible(false)
+
+	page.Entry = gtk.NewEntry()
+	page.Entry.SetHExpand(true)
+	page.Entry.SetPlaceholderText("Artist, album or track")
+	page.Entry.ConnectChanged(func() { window.scheduleSearch() })
+	page.Header.Append(page.Entry)
+
+	page.Keyboard = gtk.NewBox(gtk.OrientationVertical, 4)
+	page.Keyboard.SetHAlign(gtk.AlignCenter)
+	page.Keyboard.SetMarginBottom(8)
+	for _, keys := range keyboardRows {
+		row := gtk.NewBox(gtk.OrientationHorizontal, 4)
+		row.SetHAlign(gtk.AlignCenter)
+		for _, key := range keys {
+			text := string(key)
+			row.Append(window.mkKey(text, keyW, func() { page.Entry.SetText(page.Entry.Text() + text) }))
+		}
+		page.Keyboard.Append(row)
+	}
+	lastRow := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	lastRow.SetHAlign(gtk.AlignCenter)
+	lastRow.Append(window.mkKey("Clear", 2*keyW, func() { page.Entry.SetText("") }))
+	lastRow.Append(window.mkKey("Space", 5*keyW, func() { page.Entry.SetText(page.Entry.Text() + " ") }))
+	lastRow.Append(window.mkKey("⌫", 2*keyW, func() {
+		text := []rune(page.Entry.Text())
+		if len(text) > 0 {
+			page.Entry.SetText(string(text[:len(text)-1]))
+		}
+	}))
+	page.Keyboard.Append(lastRow)
+	page.Container.Append(page.Keyboard)
+	return page
+}
+
+func (window *MainWindow) mkKey(label string, width int, onClicked func()) *gtk.Button {
+	key := gtk.NewButtonWithLabel(label)
+	key.SetFocusOnClick(false)
+	key.SetSizeRequest(width, keyH)
+	key.ConnectClicked(onClicked)
+	if window.DarkMode {
+		key.AddCSSClass("piju-dark-button")
+	}
+	return key
+}
+
+// ShowSearch shows the search page, ready to type a new query
+func (window *MainWindow) ShowSearch() {
+	page := window.SearchPage
+	window.setState(MainWindowStateSearch)
+	page.Entry.SetText("")
+	page.ShowMessage("")
+}
+
+// scheduleSearch searches once the query has stopped changing for a while
+func (window *MainWindow) scheduleSearch() {
+	page := window.SearchPage
+	if page.timeout != 0 {
+		glib.SourceRemove(page.timeout)
+	}
+	page.timeout = glib.TimeoutAdd(searchDelayMs, func() bool {
+		page.timeout = 0
+		window.search(strings.TrimSpace(page.Entry.Text()))
+		return glib.SOURCE_REMOVE // =no need to call me again
+	})
+}
+
+func (window *MainWindow) search(query string) {
+	page := window.SearchPage
+	page.generation++
+	if len([]rune(query)) < minSearchLength {
+		page.ShowMessage("")
+		return
+	}
+	generation := page.generation
+	fetch := func(apiClient *apiclient.Client) (apiclient.SearchResults, error) {
+		return apiClient.Search(query)
+	}
+	fetchInBackground(window, fetch, func(results apiclient.SearchResults, err error) {
+		if generation != page.generation {
+			// The query has changed since
+			return
+		}
+		window.showSearchResults(results, err)
+	})
+}
+
+func (window *MainWindow) showSearchResults(results apiclient.SearchResults, err error) {
+	page := window.SearchPage
+	if err != nil {
+		page.ShowMessage(err.Error())
+		return
+	}
+	if len(results.Artists)+len(results.Albums)+len(results.Tracks) == 0 {
+		page.ShowMessage("No matches")
+		return
+	}
+	page.Clear()
+	if len(results.Artists) > 0 {
+		page.AddHeading("Artists")
+		for _, artist := range results.Artists {
+			page.AddRow("", artist.Name, "", func() {
+				apiClient := window.ApiClient
+				window.runCommand(func() error { return apiClient.SendPlayArtist(artist) })
+				window.ShowControls()
+			})
+		}
+	}
+	if len(results.Albums) > 0 {
+		page.AddHeading("Albums")
+		for _, album := range results.Albums {
+			page.AddRow("", album.Title, album.Artist, func() {
+				apiClient := window.ApiClient
+				window.runCommand(func() error { return apiClient.SendPlayAlbum(album) })
+				window.ShowControls()
+			})
+		}
+	}
+	if len(results.Tracks) > 0 {
+		page.AddHeading("Tracks")
+		for _, track := range results.Tracks {
+			details := track.Artist
+			if track.Album != "" {
+				if details != "" {
+					details += " – "
+				}
+				details += track.Album
+			}
+			page.AddRow("", track.Title, details, func() {
+				apiClient := window.ApiClient
+				window.runCommand(func() error { return apiClient.SendPlayTrack(track) })
+				window.ShowControls()
+			})
+		}
+	}
+}
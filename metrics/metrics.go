@@ -0,0 +1,233 @@
+// Package metrics collects statistics about the connection to the server,
+// the screen and the process itself, and serves them for Prometheus.
+//
+// The methods of Metrics do nothing if called on a nil *Metrics, so that
+// code that reports metrics needn't check whether they're being collected.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "piju"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	connects          prometheus.Counter
+	disconnects       prometheus.Counter
+	connected         prometheus.Gauge
+	statusMessages    prometheus.Counter
+	artworkFetches    *prometheus.CounterVec
+	artworkBytes      prometheus.Counter
+	artworkLatency    prometheus.Histogram
+	commandLatency    *prometheus.HistogramVec
+	commandFailures   *prometheus.CounterVec
+	screenTransitions *prometheus.CounterVec
+	screenBlanked     prometheus.Gauge
+
+	mutex          sync.Mutex
+	connections    int // Number of open websocket connections: normally 0 or 1
+	connectedSince time.Time
+	connectedTime  time.Duration // Total, excluding any current connection
+}
+
+// Results of fetching artwork
+const (
+	ArtworkOK          = "ok"
+	ArtworkNotModified = "not_modified" // The cached copy is still valid
+	ArtworkError       = "error"
+)
+
+func New() *Metrics {
+	metrics := &Metrics{
+		registry: prometheus.NewRegistry(),
+		connects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "websocket_connects_total",
+			Help:      "Number of times the websocket connection to the server has been established",
+		}),
+		disconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "websocket_disconnects_total",
+			Help:      "Number of times the websocket connection to the server has been lost or closed",
+		}),
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websocket_connected",
+			Help:      "1 if the websocket connection to the server is open, otherwise 0",
+		}),
+		statusMessages: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "status_messages_total",
+			Help:      "Number of status messages received from the server",
+		}),
+		artworkFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "artwork_fetches_total",
+			Help:      "Number of requests for artwork sent to the server, by result",
+		}, []string{"result"}),
+		artworkBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "artwork_bytes_total",
+			Help:      "Total size of the artwork downloaded from the server",
+		}),
+		artworkLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "artwork_fetch_duration_seconds",
+			Help:      "Time taken to fetch artwork from the server",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+		}),
+		commandLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "Time taken for the server to respond to a command, by operation",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+		}, []string{"operation"}),
+		commandFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "command_failures_total",
+			Help:      "Number of commands that failed, by operation",
+		}, []string{"operation"}),
+		screenTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "screen_blank_transitions_total",
+			Help:      "Number of times the screen has been blanked or woken, by the new state",
+		}, []string{"state"}),
+		screenBlanked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "screen_blanked",
+			Help:      "1 if the screen has been blanked, and not since woken, otherwise 0",
+		}),
+	}
+	connectedSeconds := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_connected_seconds_total",
+		Help:      "Total time for which the websocket connection to the server has been open",
+	}, metrics.connectedSeconds)
+
+	metrics.registry.MustRegister(
+		metrics.connects,
+		metrics.disconnects,
+		metrics.connected,
+		connectedSeconds,
+		metrics.statusMessages,
+		metrics.artworkFetches,
+		metrics.artworkBytes,
+		metrics.artworkLatency,
+		metrics.commandLatency,
+		metrics.commandFailures,
+		metrics.screenTransitions,
+		metrics.screenBlanked,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return metrics
+}
+
+// Handler returns an http.Handler that serves the metrics for Prometheus
+func (metrics *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the metrics at /metrics on the given address, e.g.
+// ":9100". It only returns if the server fails.
+func (metrics *Metrics) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// Connected records that the websocket connection to the server has been
+// established
+func (metrics *Metrics) Connected() {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if metrics.connections == 0 {
+		metrics.connectedSince = time.Now()
+	}
+	metrics.connections++
+	metrics.connects.Inc()
+	metrics.connected.Set(1)
+}
+
+// Disconnected records that a websocket connection recorded by Connected has
+// been lost or closed
+func (metrics *Metrics) Disconnected() {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.connections--
+	if metrics.connections == 0 {
+		metrics.connectedTime += time.Since(metrics.connectedSince)
+		metrics.connected.Set(0)
+	}
+	metrics.disconnects.Inc()
+}
+
+func (metrics *Metrics) connectedSeconds() float64 {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	connectedTime := metrics.connectedTime
+	if metrics.connections > 0 {
+		connectedTime += time.Since(metrics.connectedSince)
+	}
+	return connectedTime.Seconds()
+}
+
+// StatusReceived records that a status message has been received
+func (metrics *Metrics) StatusReceived() {
+	if metrics == nil {
+		return
+	}
+	metrics.statusMessages.Inc()
+}
+
+// ArtworkFetched records a request for artwork, with one of the Artwork...
+// results, and the size of the artwork received, if any
+func (metrics *Metrics) ArtworkFetched(result string, size int, latency time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.artworkFetches.WithLabelValues(result).Inc()
+	metrics.artworkBytes.Add(float64(size))
+	metrics.artworkLatency.Observe(latency.Seconds())
+}
+
+// CommandSent records a command sent to the server, and whether it failed.
+// operation is the endpoint, e.g. "player/play", which, unlike a description
+// of the command, has few enough values to use as a label.
+func (metrics *Metrics) CommandSent(operation string, latency time.Duration, err error) {
+	if metrics == nil {
+		return
+	}
+	metrics.commandLatency.WithLabelValues(operation).Observe(latency.Seconds())
+	if err != nil {
+		metrics.commandFailures.WithLabelValues(operation).Inc()
+	}
+}
+
+// ScreenBlankChanged records that the screen has been blanked or woken
+func (metrics *Metrics) ScreenBlankChanged(blanked bool) {
+	if metrics == nil {
+		return
+	}
+	if blanked {
+		metrics.screenTransitions.WithLabelValues("blanked").Inc()
+		metrics.screenBlanked.Set(1)
+	} else {
+		metrics.screenTransitions.WithLabelValues("woken").Inc()
+		metrics.screenBlanked.Set(0)
+	}
+}
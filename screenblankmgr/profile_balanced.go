@@ -3,18 +3,18 @@ package screenblankmgr
 type ProfileBalanced struct {
 }
 
-func (profile *ProfileBalanced) OnStartPlaying() {
+func (profile *ProfileBalanced) OnStartPlaying(screen *screen) {
 	setTimeout(300)
 }
 
-func (profile *ProfileBalanced) OnStopPlaying() {
+func (profile *ProfileBalanced) OnStopPlaying(screen *screen) {
 	setTimeout(30)
 }
 
-func (profile *ProfileBalanced) OnPlayingTick() {
+func (profile *ProfileBalanced) OnPlayingTick(screen *screen) {
 	// Do nothing except implement the interface
 }
 
-func (profile *ProfileBalanced) OnStoppedDelayed() {
-	blankScreenNow()
+func (profile *ProfileBalanced) OnStoppedDelayed(screen *screen) {
+	screen.blankNow()
 }
@@ -1,8 +1,11 @@
 package screenblankmgr
 
+// ProfileBase decides when to blank and wake the screen. Its methods are
+// called by ScreenBlankManager, which serialises them, passing the screen
+// for them to blank or wake.
 type ProfileBase interface {
-	OnStartPlaying()
-	OnStopPlaying()
-	OnPlayingTick()
-	OnStoppedDelayed()
+	OnStartPlaying(screen *screen)
+	OnStopPlaying(screen *screen)
+	OnPlayingTick(screen *screen)
+	OnStoppedDelayed(screen *screen)
 }
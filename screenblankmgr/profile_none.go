@@ -3,18 +3,18 @@ package screenblankmgr
 type ProfileNone struct {
 }
 
-func (profile *ProfileNone) OnStartPlaying() {
+func (profile *ProfileNone) OnStartPlaying(screen *screen) {
 	// Do nothing except implement the interface
 }
 
-func (profile *ProfileNone) OnStopPlaying() {
+func (profile *ProfileNone) OnStopPlaying(screen *screen) {
 	// Do nothing except implement the interface
 }
 
-func (profile *ProfileNone) OnPlayingTick() {
+func (profile *ProfileNone) OnPlayingTick(screen *screen) {
 	// Do nothing except implement the interface
 }
 
-func (profile *ProfileNone) OnStoppedDelayed() {
+func (profile *ProfileNone) OnStoppedDelayed(screen *screen) {
 	// Do nothing except implement the interface
 }
@@ -3,21 +3,21 @@ package screenblankmgr
 type ProfileOnOff struct {
 }
 
-func (profile *ProfileOnOff) OnStartPlaying() {
+func (profile *ProfileOnOff) OnStartPlaying(screen *screen) {
 	setTimeout(60 * 60)
-	profile.OnPlayingTick()
+	profile.OnPlayingTick(screen)
 }
 
-func (profile *ProfileOnOff) OnStopPlaying() {
+func (profile *ProfileOnOff) OnStopPlaying(screen *screen) {
 	setTimeout(10)
 	runXset("on")
 }
 
-func (profile *ProfileOnOff) OnPlayingTick() {
+func (profile *ProfileOnOff) OnPlayingTick(screen *screen) {
 	runXset("off")
-	runXset("reset")
+	screen.wakeNow()
 }
 
-func (profile *ProfileOnOff) OnStoppedDelayed() {
-	blankScreenNow()
+func (profile *ProfileOnOff) OnStoppedDelayed(screen *screen) {
+	screen.blankNow()
 }
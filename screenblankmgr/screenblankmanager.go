@@ -1,6 +1,11 @@
 package screenblankmgr
 
-import "nsw42/piju-touchscreen-go/apiclient"
+import (
+	"sync"
+
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/metrics"
+)
 
 const (
 	tickInterval     = 5
@@ -11,28 +16,38 @@ type ScreenBlankManager struct {
 	State         apiclient.Status
 	Profile       ProfileBase
 	TickCountdown int
+	Metrics       *metrics.Metrics // May be nil
+
+	// mutex is held throughout SetState, Blank and Wake, which may be
+	// called from different goroutines, so that each sees the screen as the
+	// previous one left it
+	mutex  sync.Mutex
+	screen screen
 }
 
 func NewScreenBlankManager(profile ProfileBase) *ScreenBlankManager {
 	return &ScreenBlankManager{
-		apiclient.Error,
-		profile,
-		tickInterval,
+		State:         apiclient.Error,
+		Profile:       profile,
+		TickCountdown: tickInterval,
 	}
 }
 
 func (manager *ScreenBlankManager) SetState(newState apiclient.Status) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	defer manager.reportBlankChange(manager.screen.blanked)
 	if (manager.State == apiclient.Playing && newState == apiclient.Playing) || (manager.State != apiclient.Playing && newState != apiclient.Playing) {
 		// State is, to all intents and purposes, unchanged
 		manager.TickCountdown -= 1
 		if manager.TickCountdown == 0 {
 			if manager.State == apiclient.Playing {
 				// Playing: tick the profile
-				manager.Profile.OnPlayingTick()
+				manager.Profile.OnPlayingTick(&manager.screen)
 				manager.TickCountdown = tickInterval
 			} else {
 				// Not playing - notify profile of (delayed) stop
-				manager.Profile.OnStoppedDelayed()
+				manager.Profile.OnStoppedDelayed(&manager.screen)
 				// Don't reset the countdown: we only call it once
 			}
 		}
@@ -40,11 +55,49 @@ func (manager *ScreenBlankManager) SetState(newState apiclient.Status) {
 		// State has changed
 		manager.State = newState
 		if newState == apiclient.Playing {
-			manager.Profile.OnStartPlaying()
+			// Not every profile wakes the screen, but playback is normally
+			// started by touching it, which does, so assume it's awake
+			manager.screen.blanked = false
+			manager.Profile.OnStartPlaying(&manager.screen)
 			manager.TickCountdown = tickInterval
 		} else {
-			manager.Profile.OnStopPlaying()
+			manager.Profile.OnStopPlaying(&manager.screen)
 			manager.TickCountdown = delayStopTimeout
 		}
 	}
 }
+
+// Blank blanks the screen now, whatever the profile. It wakes again when
+// touched, or as the profile dictates when playback starts.
+func (manager *ScreenBlankManager) Blank() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	defer manager.reportBlankChange(manager.screen.blanked)
+	manager.screen.blankNow()
+}
+
+// Wake unblanks the screen now, whatever the profile. It will blank again
+// when the screensaver timeout set by the profile next expires.
+func (manager *ScreenBlankManager) Wake() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	defer manager.reportBlankChange(manager.screen.blanked)
+	manager.screen.wakeNow()
+}
+
+// Blanked returns whether the screen has been blanked, either by the profile
+// or by Blank, and not since woken by the profile or by Wake, nor playback
+// started
+func (manager *ScreenBlankManager) Blanked() bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.screen.blanked
+}
+
+// reportBlankChange updates the metrics if the screen has been blanked or
+// woken since it was in the given state. The mutex must be held.
+func (manager *ScreenBlankManager) reportBlankChange(wasBlanked bool) {
+	if blanked := manager.screen.blanked; blanked != wasBlanked {
+		manager.Metrics.ScreenBlankChanged(blanked)
+	}
+}
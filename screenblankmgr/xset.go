@@ -1,15 +1,32 @@
 package screenblankmgr
 
 import (
-	"fmt"
 	"os/exec"
 	"strconv"
+
+	"nsw42/piju-touchscreen-go/logging"
 )
 
+var logger = logging.For("screenblank")
+
+// screen runs xset to blank and wake the screen, and tracks whether we have
+// blanked it, and not since woken it or seen playback start. The screen can
+// also be woken by touching it, or blanked by X when the timeout expires,
+// neither of which we can see.
+type screen struct {
+	blanked bool
+}
+
 // Functions to call xset
 
-func blankScreenNow() {
+func (screen *screen) blankNow() {
 	runXset("activate")
+	screen.blanked = true
+}
+
+func (screen *screen) wakeNow() {
+	runXset("reset")
+	screen.blanked = false
 }
 
 func setTimeout(timeout int) {
@@ -17,10 +34,10 @@ func setTimeout(timeout int) {
 }
 
 func runXset(sArg string) {
-	fmt.Println("xset", "s", sArg)
+	logger.Debug("Running xset", "args", "s "+sArg)
 	cmd := exec.Command("xset", "s", sArg)
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println("Error running xset: ", err)
+		logger.Error("Error running xset", "args", "s "+sArg, "err", err)
 	}
 }
@@ -0,0 +1,390 @@
+// Package tui is a terminal frontend for piju, for use over SSH or on a bare
+// tty, with no need for GTK or a display. It uses only ANSI escape sequences,
+// so works on the Linux console as well as terminal emulators.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"nsw42/piju-touchscreen-go/apiclient"
+	"nsw42/piju-touchscreen-go/frontend"
+)
+
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+	bold        = "\x1b[1m"
+	dim         = "\x1b[2m"
+	reset       = "\x1b[0m"
+
+	// How long to show error messages for
+	messageDuration = 4 * time.Second
+
+	defaultWidth = 80
+)
+
+const help = "space pause/resume   n/→ next   p/← previous   +/- volume   s shuffle   r repeat   q quit"
+
+// intents maps keys, and the escape sequences for arrow keys, to what they do
+var intents = map[string]frontend.Intent{
+	" ":      frontend.PlayPause,
+	"n":      frontend.Next,
+	"N":      frontend.Next,
+	"\x1b[C": frontend.Next,
+	"p":      frontend.Previous,
+	"P":      frontend.Previous,
+	"\x1b[D": frontend.Previous,
+	"+":      frontend.VolumeUp,
+	"=":      frontend.VolumeUp,
+	"\x1b[A": frontend.VolumeUp,
+	"-":      frontend.VolumeDown,
+	"_":      frontend.VolumeDown,
+	"\x1b[B": frontend.VolumeDown,
+	"s":      frontend.Shuffle,
+	"S":      frontend.Shuffle,
+	"r":      frontend.Repeat,
+	"R":      frontend.Repeat,
+}
+
+// TUI shows the status of a piju server in the terminal, and sends commands
+// in response to key presses. It implements frontend.Frontend.
+type TUI struct {
+	in  *os.File
+	out io.Writer
+
+	mutex           sync.Mutex
+	apiClient       *apiclient.Client
+	nowPlaying      apiclient.NowPlaying
+	connectionState apiclient.ConnectionState
+	retryAt         time.Time
+	searching       bool
+	servers         []apiclient.Server
+	activeServer    int
+	onSwitch        func(index int)
+	choosingServer  bool
+	message         string
+	messageTime     time.Time
+	redraw          chan struct{}
+}
+
+// New creates a TUI reading keys from in, and drawing to out. in should be a
+// terminal, but if it isn't, keys take effect when Enter is pressed.
+func New(in *os.File, out io.Writer) *TUI {
+	return &TUI{
+		in:              in,
+		out:             out,
+		apiClient:       apiclient.NewClient(""),
+		nowPlaying:      apiclient.NowPlaying{Status: apiclient.Error},
+		connectionState: apiclient.Disconnected,
+		redraw:          make(chan struct{}, 1),
+	}
+}
+
+var _ frontend.Frontend = &TUI{}
+
+// SetApiClient switches to a different server
+func (ui *TUI) SetApiClient(serverIndex int, apiClient *apiclient.Client) {
+	ui.update(func() {
+		ui.apiClient = apiClient
+		ui.activeServer = serverIndex
+		ui.searching = false
+		ui.choosingServer = false
+		ui.nowPlaying = apiclient.NowPlaying{Status: apiclient.Error}
+	})
+}
+
+// ShowSearching shows whether we are looking for a server to connect to
+func (ui *TUI) ShowSearching(searching bool) {
+	ui.update(func() { ui.searching = searching })
+}
+
+// ShowServers allows switching between servers by pressing their number
+func (ui *TUI) ShowServers(servers []apiclient.Server, onSwitch func(index int)) {
+	ui.update(func() {
+		ui.servers = servers
+		ui.onSwitch = onSwitch
+	})
+}
+
+// ChooseServer asks the user to press the number of a server to connect to
+func (ui *TUI) ChooseServer(servers []apiclient.Server, onChosen func(index int)) {
+	ui.update(func() {
+		ui.servers = servers
+		ui.onSwitch = onChosen
+		ui.choosingServer = true
+		ui.searching = false
+	})
+}
+
+func (ui *TUI) ShowNowPlaying(nowPlaying apiclient.NowPlaying) {
+	ui.update(func() { ui.nowPlaying = nowPlaying })
+}
+
+func (ui *TUI) ShowConnectionState(state apiclient.ConnectionState, retryAt time.Time) {
+	ui.update(func() {
+		ui.connectionState = state
+		ui.retryAt = retryAt
+		if state != apiclient.Connected {
+			ui.nowPlaying = apiclient.NowPlaying{Status: apiclient.Error}
+		}
+	})
+}
+
+func (ui *TUI) showMessage(message string) {
+	ui.update(func() {
+		ui.message = message
+		ui.messageTime = time.Now()
+	})
+}
+
+// update changes the state under the mutex, then asks for a redraw
+func (ui *TUI) update(change func()) {
+	ui.mutex.Lock()
+	change()
+	ui.mutex.Unlock()
+	select {
+	case ui.redraw <- struct{}{}:
+	default:
+		// A redraw is already pending
+	}
+}
+
+// Run draws the UI and handles key presses until the user quits
+func (ui *TUI) Run() error {
+	if term.IsTerminal(int(ui.in.Fd())) {
+		oldState, err := term.MakeRaw(int(ui.in.Fd()))
+		if err != nil {
+			return err
+		}
+		defer term.Restore(int(ui.in.Fd()), oldState)
+	}
+	fmt.Fprint(ui.out, hideCursor)
+	defer fmt.Fprint(ui.out, clearScreen+showCursor)
+
+	keys := make(chan string)
+	go readKeys(ui.in, keys)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		ui.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !ui.handleKey(key) {
+				return nil
+			}
+		case <-ui.redraw:
+		case <-ticker.C:
+			// Keep the progress and any countdown up to date
+		}
+	}
+}
+
+// readKeys sends each key press, or escape sequence, to keys, closing it
+// when in reaches end of file
+func readKeys(in io.Reader, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		input := string(buf[:n])
+		if strings.HasPrefix(input, "\x1b") {
+			keys <- input
+			continue
+		}
+		for _, key := range input {
+			keys <- string(key)
+		}
+	}
+}
+
+// handleKey acts on a key press, returning false if the user wants to quit
+func (ui *TUI) handleKey(key string) bool {
+	ui.mutex.Lock()
+	apiClient := ui.apiClient
+	nowPlaying := ui.nowPlaying
+	servers := ui.servers
+	onSwitch := ui.onSwitch
+	ui.mutex.Unlock()
+
+	switch key {
+	case "q", "Q", "\x03", "\x04": // Ctrl-C and Ctrl-D work too, as the terminal is raw
+		return false
+	}
+	if index, err := strconv.Atoi(key); err == nil && index >= 1 && index <= len(servers) && onSwitch != nil {
+		go onSwitch(index - 1)
+		return true
+	}
+	if intent, ok := intents[key]; ok && nowPlaying.Status != apiclient.Error {
+		go func() {
+			if err := frontend.Perform(apiClient, intent, nowPlaying); err != nil {
+				ui.showMessage(err.Error())
+			}
+		}()
+	}
+	return true
+}
+
+func (ui *TUI) draw() {
+	ui.mutex.Lock()
+	lines := ui.lines()
+	ui.mutex.Unlock()
+
+	width := defaultWidth
+	if w, _, err := term.GetSize(int(ui.in.Fd())); err == nil && w > 0 {
+		width = w
+	}
+	var screen strings.Builder
+	screen.WriteString(clearScreen)
+	for _, line := range lines {
+		screen.WriteString(truncate(line, width))
+		// The terminal is raw, so newlines don't imply carriage returns
+		screen.WriteString(reset + "\r\n")
+	}
+	io.WriteString(ui.out, screen.String())
+}
+
+// lines returns the text to show. The mutex must be held.
+func (ui *TUI) lines() []string {
+	nowPlaying := ui.nowPlaying
+	lines := []string{bold + "piju" + reset + "  " + dim + ui.apiClient.Host + reset, ""}
+
+	if ui.choosingServer {
+		lines = append(lines, "  Choose a server:", "")
+		for index, server := range ui.servers {
+			lines = append(lines, "  "+strconv.Itoa(index+1)+"  "+server.Name)
+		}
+	} else if nowPlaying.Status == apiclient.Error {
+		lines = append(lines, "  "+ui.connectionText())
+	} else {
+		status := "  " + statusSymbol(nowPlaying.Status) + " " + nowPlaying.Status.String()
+		if nowPlaying.Scanning {
+			status += "   " + dim + "(scanning library)" + reset
+		}
+		lines = append(lines, status, "")
+		switch {
+		case nowPlaying.IsTrack:
+			lines = append(lines, "  "+bold+nowPlaying.TrackName+reset, "  "+nowPlaying.ArtistName)
+			if nowPlaying.AlbumName != "" {
+				lines = append(lines, "  "+dim+nowPlaying.AlbumName+reset)
+			}
+			var position string
+			if nowPlaying.AlbumTracks > 0 {
+				position = "Track " + strconv.Itoa(nowPlaying.TrackNumber) + " of " + strconv.Itoa(nowPlaying.AlbumTracks)
+			}
+			switch {
+			case nowPlaying.Duration > 0 && nowPlaying.PositionKnown:
+				elapsed := nowPlaying.ElapsedAt(time.Now())
+				position += "   " + frontend.FormatDuration(elapsed) + " / " + frontend.FormatDuration(nowPlaying.Duration)
+			case nowPlaying.Duration > 0:
+				position += "   " + frontend.FormatDuration(nowPlaying.Duration)
+			}
+			lines = append(lines, "  "+strings.TrimSpace(position))
+		case nowPlaying.StreamName != "":
+			lines = append(lines, "  "+bold+nowPlaying.StreamName+reset)
+		default:
+			lines = append(lines, "  No track")
+		}
+		lines = append(lines, "", "  "+ui.optionsText())
+	}
+
+	if len(ui.servers) > 1 && !ui.choosingServer {
+		var serverNames []string
+		for index, server := range ui.servers {
+			name := strconv.Itoa(index+1) + " " + server.Name
+			if index == ui.activeServer {
+				name = bold + name + reset + dim
+			}
+			serverNames = append(serverNames, name)
+		}
+		lines = append(lines, "", "  "+dim+"Servers: "+strings.Join(serverNames, "   ")+reset)
+	}
+
+	lines = append(lines, "")
+	if ui.message != "" && time.Since(ui.messageTime) < messageDuration {
+		lines = append(lines, "  "+ui.message)
+	} else {
+		lines = append(lines, "")
+	}
+	return append(lines, "", dim+help+reset)
+}
+
+// connectionText explains why there's nothing to show. The mutex must be held.
+func (ui *TUI) connectionText() string {
+	if ui.searching {
+		return "Searching for server…"
+	}
+	switch ui.connectionState {
+	case apiclient.Connecting:
+		return "Connecting…"
+	case apiclient.Backoff:
+		seconds := int(time.Until(ui.retryAt).Seconds() + 0.999)
+		if seconds > 0 {
+			return "Reconnecting in " + strconv.Itoa(seconds) + " s"
+		}
+		return "Reconnecting…"
+	}
+	return "Connection error"
+}
+
+// optionsText describes the volume and play modes. The mutex must be held.
+func (ui *TUI) optionsText() string {
+	nowPlaying := ui.nowPlaying
+	var options []string
+	if nowPlaying.Volume != apiclient.UnknownVolume {
+		options = append(options, "Volume "+strconv.Itoa(nowPlaying.Volume)+"%")
+	}
+	if nowPlaying.Shuffle != apiclient.ModeUnknown {
+		options = append(options, "Shuffle "+nowPlaying.Shuffle.String())
+	}
+	if nowPlaying.Repeat != apiclient.ModeUnknown {
+		options = append(options, "Repeat "+nowPlaying.Repeat.String())
+	}
+	return strings.Join(options, "   ")
+}
+
+func statusSymbol(status apiclient.Status) string {
+	switch status {
+	case apiclient.Playing:
+		return "▶"
+	case apiclient.Paused:
+		return "⏸"
+	}
+	return "■"
+}
+
+// truncate shortens a line to fit within width columns, ignoring escape
+// sequences, which take no space
+func truncate(line string, width int) string {
+	var out strings.Builder
+	columns := 0
+	inEscape := false
+	for _, r := range line {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				inEscape = false
+			}
+		default:
+			if columns >= width {
+				continue
+			}
+			columns++
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,144 @@
+// Package watchdog restarts the process when its memory use grows too large,
+// or at a quiet time of day, to work around the memory leak in gotk4.
+// Restarting replaces the running process with a new one, with the same
+// arguments, so it needs no help from a supervisor or cron job.
+package watchdog
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"nsw42/piju-touchscreen-go/logging"
+)
+
+var logger = logging.For("watchdog")
+
+// NoQuietHour disables restarting at a quiet hour
+const NoQuietHour = -1
+
+const (
+	checkInterval = time.Minute
+
+	// minUptime is how long to run before restarting because of memory use,
+	// so that a limit below what's needed to start up can't make the process
+	// restart every minute
+	minUptime = 10 * time.Minute
+
+	// reasonEnv passes the reason for a restart to the new process, so that
+	// it can be logged along with everything else from that process
+	reasonEnv = "PIJU_TOUCHSCREEN_RESTART_REASON"
+)
+
+type Watchdog struct {
+	// MaxRSS is the resident set size, in bytes, above which to restart
+	// immediately, or 0 for no limit
+	MaxRSS int64
+	// QuietHour is the hour of the day, 0-23, during which to restart if
+	// Idle returns true, or NoQuietHour. It restarts at most once a day.
+	QuietHour int
+	// Idle returns whether nobody is likely to be using the UI, e.g. because
+	// nothing is playing and the screen is blanked
+	Idle func() bool
+	// Args are the arguments to restart with, including the program name
+	Args []string
+	// BeforeRestart, if set, is called just before restarting, to finish
+	// writing any files that exec would otherwise abandon
+	BeforeRestart func()
+
+	started       time.Time
+	reportedEarly bool // Whether we've logged that MaxRSS was exceeded within minUptime
+}
+
+// Run checks the memory use and the time every minute, restarting if
+// necessary, and never returns. It should be run in its own goroutine.
+func (watchdog *Watchdog) Run() {
+	watchdog.started = time.Now()
+	if watchdog.MaxRSS > 0 {
+		if _, err := residentSetSize(); err != nil {
+			logger.Error("Unable to monitor memory use", "err", err)
+			watchdog.MaxRSS = 0
+		}
+	}
+	for now := range time.Tick(checkInterval) {
+		if reason := watchdog.check(now); reason != "" {
+			watchdog.restart(reason)
+		}
+	}
+}
+
+// check returns why the process should be restarted, or "" if it shouldn't
+func (watchdog *Watchdog) check(now time.Time) string {
+	if watchdog.MaxRSS > 0 {
+		rss, err := residentSetSize()
+		if err == nil && rss > watchdog.MaxRSS {
+			if now.Sub(watchdog.started) >= minUptime {
+				return "memory use of " + megabytes(rss) + " exceeds limit of " + megabytes(watchdog.MaxRSS)
+			}
+			if !watchdog.reportedEarly {
+				logger.Error("Memory use exceeds limit straight after starting: the limit is probably too low",
+					"rss", megabytes(rss), "limit", megabytes(watchdog.MaxRSS), "restartIn", (minUptime - now.Sub(watchdog.started)).Round(time.Minute))
+				watchdog.reportedEarly = true
+			}
+		}
+	}
+	// Having been running for over an hour means we can't have restarted
+	// earlier in this quiet hour, so can't restart repeatedly
+	if watchdog.QuietHour != NoQuietHour && now.Hour() == watchdog.QuietHour &&
+		now.Sub(watchdog.started) > time.Hour && watchdog.Idle() {
+		rss, _ := residentSetSize()
+		return "quiet hour, with memory use of " + megabytes(rss)
+	}
+	return ""
+}
+
+// restart replaces the process with a new copy of itself. It only returns
+// if that fails.
+func (watchdog *Watchdog) restart(reason string) {
+	logger.Warn("Restarting", "reason", reason)
+	executable, err := os.Executable()
+	if err == nil {
+		if watchdog.BeforeRestart != nil {
+			watchdog.BeforeRestart()
+		}
+		env := append(os.Environ(), reasonEnv+"="+reason)
+		// Files, including network listeners, are opened close-on-exec, so
+		// the new process starts afresh
+		err = syscall.Exec(executable, watchdog.Args, env)
+	}
+	logger.Error("Unable to restart", "err", err)
+}
+
+// LogRestart logs why the process was restarted, if it was restarted by a
+// Watchdog. It should be called once logging has been set up.
+func LogRestart() {
+	if reason, ok := os.LookupEnv(reasonEnv); ok {
+		logger.Info("Restarted by watchdog", "reason", reason)
+		os.Unsetenv(reasonEnv)
+	}
+}
+
+// residentSetSize returns how much of the process's memory, in bytes, is in
+// RAM. It relies on /proc, so only works on Linux.
+func residentSetSize() (int64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, errors.New("Unexpected format of /proc/self/statm")
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
+
+func megabytes(bytes int64) string {
+	return strconv.FormatInt(bytes/1024/1024, 10) + "MB"
+}